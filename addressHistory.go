@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// addrPageSize is how many transactions of an address's history are
+	// returned when the request does not say, and addrMaxPageSize the most
+	// returned at once.
+	addrPageSize    = 25
+	addrMaxPageSize = 500
+)
+
+// AddressQuery selects a page of an address's confirmed history, which runs
+// from the newest transaction to the oldest. Cursor is the nextcursor of the
+// previous page, empty for the first one.
+//
+// Direction "in" keeps transactions that added to the balance and "out" those
+// that took from it. MinHeight and MaxHeight bound their height. MinAmount is
+// the smallest balance change, either way, to include, in the unit of the
+// request.
+type AddressQuery struct {
+	Cursor    string  `json:"cursor"`
+	Limit     int     `json:"limit"`
+	Direction string  `json:"direction"`
+	MinHeight int     `json:"minheight"`
+	MaxHeight int     `json:"maxheight"`
+	MinAmount float64 `json:"minamount"`
+
+	minSats Amount
+}
+
+// AddressPage is a page of an address's history. Total is how many confirmed
+// transactions match the query over all pages. The balance history covers
+// the whole confirmed history regardless of the query, and is only on the
+// first page. Without the index it is left out when the history is longer
+// than a page, and BalanceHistoryTruncated says so. Pending holds every
+// unconfirmed transaction, unfiltered, newest first.
+type AddressPage struct {
+	Balance                 AddrBal               `json:"balance"`
+	Pending                 []PendingTransaction  `json:"pending"`
+	TxHistory               []FullHistTransaction `json:"txhistory"`
+	BalanceHistory          []AddrBalHistory      `json:"balancehistory,omitempty"`
+	BalanceHistoryTruncated bool                  `json:"balancehistory_truncated,omitempty"`
+	Total                   int                   `json:"total"`
+	NextCursor              string                `json:"nextcursor,omitempty"`
+}
+
+// PendingTransaction is an unconfirmed transaction of an address. FirstSeen
+// is when the node first saw the transaction, if it still has it.
+type PendingTransaction struct {
+	FullHistTransaction
+	Fee       Amount `json:"fee"`
+	FirstSeen int64  `json:"firstseen"`
+}
+
+// validate checks q, fills in the default limit and converts the minimum
+// amount from unit.
+func (q *AddressQuery) validate(unit amountUnit) error {
+	if q.Limit < 0 || q.MinHeight < 0 || q.MaxHeight < 0 || q.MinAmount < 0 {
+		return errors.New("negative limit, height or amount")
+	}
+	if q.Direction != "" && q.Direction != "in" && q.Direction != "out" {
+		return fmt.Errorf("invalid direction %q", q.Direction)
+	}
+	if q.Cursor != "" {
+		if _, err := parseHistCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+	q.minSats = unit.toSats(q.MinAmount)
+	if q.Limit == 0 {
+		q.Limit = addrPageSize
+	}
+	if q.Limit > addrMaxPageSize {
+		q.Limit = addrMaxPageSize
+	}
+	return nil
+}
+
+// addressQueryFromURL reads an AddressQuery from query parameters named like
+// its JSON fields.
+func addressQueryFromURL(values url.Values) (AddressQuery, error) {
+	q := AddressQuery{
+		Cursor:    values.Get("cursor"),
+		Direction: values.Get("direction"),
+	}
+	ints := map[string]*int{"limit": &q.Limit, "minheight": &q.MinHeight, "maxheight": &q.MaxHeight}
+	for name, v := range ints {
+		if s := values.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*v = n
+		}
+	}
+	if s := values.Get("minamount"); s != "" {
+		amount, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid minamount %q", s)
+		}
+		q.MinAmount = amount
+	}
+	return q, nil
+}
+
+// histCursor returns the cursor to continue after tx, "<height>:<txid>".
+func histCursor(tx HistoryTransaction) string {
+	return strconv.Itoa(tx.Height) + ":" + tx.TxHash
+}
+
+func parseHistCursor(cursor string) (HistoryTransaction, error) {
+	heightStr, txid, ok := strings.Cut(cursor, ":")
+	height, err := strconv.Atoi(heightStr)
+	if !ok || err != nil || height <= 0 || len(txid) != 64 {
+		return HistoryTransaction{}, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return HistoryTransaction{TxHash: txid, Height: height}, nil
+}
+
+// histBefore orders a confirmed history newest first.
+func histBefore(a, b HistoryTransaction) bool {
+	if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	return a.TxHash < b.TxHash
+}
+
+// matchesHeight reports whether tx is within the query's height range.
+func (q *AddressQuery) matchesHeight(tx HistoryTransaction) bool {
+	return tx.Height >= q.MinHeight && (q.MaxHeight == 0 || tx.Height <= q.MaxHeight)
+}
+
+// matchesChange reports whether a balance change passes the direction and
+// amount filters.
+func (q *AddressQuery) matchesChange(delta Amount) bool {
+	switch {
+	case q.Direction == "in" && delta <= 0:
+		return false
+	case q.Direction == "out" && delta >= 0:
+		return false
+	}
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta >= q.minSats
+}
+
+// needsChanges reports whether filtering needs the balance change of every
+// transaction in the height range.
+func (q *AddressQuery) needsChanges() bool {
+	return q.Direction != "" || q.MinAmount > 0
+}
+
+// resolveHistTxs fetches the given transactions of an address's history
+// along with everything they spend, and works out their balance changes.
+func resolveHistTxs(ctx context.Context, histTxs []HistoryTransaction, addr string, currentHeight int, cc *chainConfig) (map[string]FullHistTransaction, error) {
+	resolved := make(map[string]FullHistTransaction, len(histTxs))
+	if len(histTxs) == 0 {
+		return resolved, nil
+	}
+
+	// Fetch the transactions and then everything they spend, one batch each
+	txids := make([]string, 0, len(histTxs))
+	for _, t := range histTxs {
+		txids = append(txids, t.TxHash)
+	}
+	txs, err := getTxs(ctx, txids, cc)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]ElectrumTransaction, 0, len(txs))
+	for _, tx := range txs {
+		found = append(found, tx)
+	}
+	prevTxs, err := getPrevTxs(ctx, found, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range histTxs {
+		fullTx, err := getFullHistTx(t, txs[t.TxHash], addr, currentHeight, prevTxs, cc)
+		if err != nil {
+			return nil, err
+		}
+		fullTx.BalanceChange = getBalanceChange(fullTx, addr)
+		resolved[t.TxHash] = fullTx
+	}
+	return resolved, nil
+}
+
+// pendingTxs returns the resolved unconfirmed transactions of an address,
+// newest first, with their fees and when the node first saw them.
+func pendingTxs(ctx context.Context, unconfirmed []HistoryTransaction, resolved map[string]FullHistTransaction, cc *chainConfig) ([]PendingTransaction, error) {
+	pending := make([]PendingTransaction, 0, len(unconfirmed))
+	if len(unconfirmed) == 0 {
+		return pending, nil
+	}
+
+	txids := make([]string, 0, len(unconfirmed))
+	for _, t := range unconfirmed {
+		txids = append(txids, t.TxHash)
+	}
+	entries, err := cc.rpc.getMempoolEntries(ctx, txids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range unconfirmed {
+		fullTx := resolved[t.TxHash]
+		// Height -1 means the transaction spends unconfirmed outputs
+		fullTx.Height = 0
+		tx := PendingTransaction{FullHistTransaction: fullTx, Fee: t.Fee}
+		if entry, ok := entries[t.TxHash]; ok {
+			tx.FirstSeen = entry.Time
+			if t.Fee == 0 {
+				tx.Fee = toSats(entry.Fees.Base)
+			}
+		}
+		pending = append(pending, tx)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].FirstSeen != pending[j].FirstSeen {
+			return pending[i].FirstSeen > pending[j].FirstSeen
+		}
+		return pending[i].TxID < pending[j].TxID
+	})
+	return pending, nil
+}
+
+// balanceHistory returns the balance after every transaction in a confirmed
+// history, given their balance changes, oldest first.
+func balanceHistory(histTxs []HistoryTransaction, changes map[string]Amount, currentHeight int) []AddrBalHistory {
+	balHist := make([]AddrBalHistory, 0)
+	if len(histTxs) == 0 {
+		return balHist
+	}
+
+	txs := append([]HistoryTransaction(nil), histTxs...)
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Height < txs[j].Height
+	})
+
+	var balance Amount
+	balHist = append(balHist, AddrBalHistory{txs[0].Height - 1, 0})
+	for _, tx := range txs {
+		balance += changes[tx.TxHash]
+		balHist = append(balHist, AddrBalHistory{tx.Height, balance})
+	}
+	if balHist[len(balHist)-1].Block != currentHeight {
+		balHist = append(balHist, AddrBalHistory{currentHeight, balHist[len(balHist)-1].Balance})
+	}
+	return balHist
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// Amount is an amount of coins in satoshis. In JSON it is an object with the
+// exact number of satoshis and the same amount as a decimal string, in coins
+// unless the request asked for another unit:
+//
+//	{"sats":150000000,"value":"1.50000000"}
+type Amount btcutil.Amount
+
+type amountJSON struct {
+	Sats  int64  `json:"sats"`
+	Value string `json:"value"`
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(amountJSON{Sats: int64(a), Value: unitCoins.format(a)})
+}
+
+// UnmarshalJSON accepts the object form as well as a plain number of
+// satoshis, which is how Electrum servers give amounts.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var sats int64
+	if err := json.Unmarshal(data, &sats); err == nil {
+		*a = Amount(sats)
+		return nil
+	}
+	var obj amountJSON
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*a = Amount(obj.Sats)
+	return nil
+}
+
+// toSats converts an amount in coins, as Core gives it, to satoshis.
+func toSats(value float64) Amount {
+	amount, _ := btcutil.NewAmount(value)
+	return Amount(amount)
+}
+
+// amountUnit is a unit amounts can be shown in, by how many decimal places it
+// has.
+type amountUnit struct {
+	name     string
+	decimals int
+}
+
+var (
+	unitCoins = amountUnit{"coins", 8}
+	unitMilli = amountUnit{"milli", 5}
+	unitSats  = amountUnit{"sats", 0}
+)
+
+// parseAmountUnit parses the unit query parameter. Milli-coins can be asked
+// for as mNMC or mBTC on either chain.
+func parseAmountUnit(s string) (amountUnit, error) {
+	switch strings.ToLower(s) {
+	case "", "coins", "nmc", "btc":
+		return unitCoins, nil
+	case "milli", "mnmc", "mbtc":
+		return unitMilli, nil
+	case "sats", "sat", "satoshis":
+		return unitSats, nil
+	}
+	return amountUnit{}, fmt.Errorf("invalid unit %q", s)
+}
+
+// requestUnit returns the unit a request asked for with ?unit=.
+func requestUnit(r *http.Request) (amountUnit, error) {
+	return parseAmountUnit(r.URL.Query().Get("unit"))
+}
+
+// format returns a as a decimal string in the unit, without rounding.
+func (u amountUnit) format(a Amount) string {
+	sats := int64(a)
+	if u.decimals == 0 {
+		return strconv.FormatInt(sats, 10)
+	}
+	sign := ""
+	abs := uint64(sats)
+	if sats < 0 {
+		sign = "-"
+		abs = uint64(-sats)
+	}
+	div := uint64(math.Pow10(u.decimals))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/div, u.decimals, abs%div)
+}
+
+// toSats converts an amount given in the unit to satoshis.
+func (u amountUnit) toSats(value float64) Amount {
+	return Amount(math.Round(value * math.Pow10(u.decimals)))
+}
+
+// amountType is the type marshalWithUnit gives in the request's unit.
+var amountType = reflect.TypeOf(Amount(0))
+
+// marshalWithUnit is json.Marshal with the decimal strings of all amounts in
+// the given unit.
+func marshalWithUnit(v interface{}, unit amountUnit) ([]byte, error) {
+	if unit == unitCoins {
+		return json.Marshal(v)
+	}
+	e := unitEncoder{unit: unit}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// unitEncoder encodes values the way encoding/json does, following their
+// types down to the amounts, which it writes in its unit. Anything that
+// cannot hold an amount is left to json.Marshal.
+type unitEncoder struct {
+	buf  bytes.Buffer
+	unit amountUnit
+}
+
+func (e *unitEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteString("null")
+		return nil
+	}
+	t := v.Type()
+	if t == amountType {
+		return e.marshal(amountJSON{Sats: v.Int(), Value: e.unit.format(Amount(v.Int()))})
+	}
+	if !containsAmount(t) {
+		if !v.CanInterface() {
+			return fmt.Errorf("cannot encode unexported %s", t)
+		}
+		return e.marshal(v.Interface())
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		e.buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(']')
+		return nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+		if v.IsNil() {
+			e.buf.WriteString("null")
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		e.buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if err := e.marshal(key.String()); err != nil {
+				return err
+			}
+			e.buf.WriteByte(':')
+			if err := e.encode(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte('}')
+		return nil
+	case reflect.Struct:
+		e.buf.WriteByte('{')
+		first := true
+		if err := e.encodeFields(v, &first); err != nil {
+			return err
+		}
+		e.buf.WriteByte('}')
+		return nil
+	}
+	return fmt.Errorf("cannot encode %s", t)
+}
+
+// encodeFields writes the fields of the struct v by their json tags, with
+// the fields of embedded structs in line.
+func (e *unitEncoder) encodeFields(v reflect.Value, first *bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				ft, fv = ft.Elem(), fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := e.encodeFields(fv, first); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if !*first {
+			e.buf.WriteByte(',')
+		}
+		*first = false
+		if err := e.marshal(name); err != nil {
+			return err
+		}
+		e.buf.WriteByte(':')
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *unitEncoder) marshal(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.buf.Write(data)
+	return nil
+}
+
+// isEmptyValue reports whether omitempty leaves v out, as encoding/json
+// decides it.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// amountTypes caches containsAmount by type.
+var amountTypes sync.Map
+
+// containsAmount reports whether a value of type t can hold an Amount.
+func containsAmount(t reflect.Type) bool {
+	if has, ok := amountTypes.Load(t); ok {
+		return has.(bool)
+	}
+	has := typeContainsAmount(t, make(map[reflect.Type]bool))
+	amountTypes.Store(t, has)
+	return has
+}
+
+// typeContainsAmount is containsAmount without the cache. seen holds the
+// types already looked at, for types that refer to themselves.
+func typeContainsAmount(t reflect.Type, seen map[reflect.Type]bool) bool {
+	// Whatever an interface holds is only known from the value
+	if t == amountType || t.Kind() == reflect.Interface {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return typeContainsAmount(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if typeContainsAmount(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalWithUnit(t *testing.T) {
+	page := AddressPage{
+		Balance: AddrBal{Confirmed: 150000000, UnconfirmedTxs: []UnconfirmedChange{{TxID: "a", Change: -25}}},
+		Pending: []PendingTransaction{{
+			FullHistTransaction: FullHistTransaction{TxID: "b", BalanceChange: -25},
+			Fee:                 1000,
+		}},
+		TxHistory:      []FullHistTransaction{},
+		BalanceHistory: []AddrBalHistory{{Block: 1, Balance: 150000000}},
+	}
+
+	// In coins the encoder must give what json.Marshal gives
+	want, err := json.Marshal(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := unitEncoder{unit: unitCoins}
+	if err := e.encode(reflect.ValueOf(page)); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.buf.String(); got != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	tests := []struct {
+		desc string
+		v    interface{}
+		unit amountUnit
+		want string
+	}{
+		{
+			desc: "amounts in satoshis",
+			v:    UnconfirmedChange{TxID: "a", Change: -150000000},
+			unit: unitSats,
+			want: `{"txid":"a","change":{"sats":-150000000,"value":"-150000000"}}`,
+		},
+		{
+			desc: "amounts in milli-coins",
+			v:    map[string]interface{}{"x": []Amount{1, 150000000}, "y": nil},
+			unit: unitMilli,
+			want: `{"x":[{"sats":1,"value":"0.00001"},{"sats":150000000,"value":"1500.00000"}],"y":null}`,
+		},
+		{
+			desc: "string shaped like an amount",
+			v: struct {
+				Value string          `json:"value"`
+				Raw   json.RawMessage `json:"raw"`
+				Sats  *Amount         `json:"sats,omitempty"`
+			}{`{"sats":5,"value":"0.00000005"}`, json.RawMessage(`{"sats":5,"value":"0.00000005"}`), nil},
+			unit: unitSats,
+			want: `{"value":"{\"sats\":5,\"value\":\"0.00000005\"}","raw":{"sats":5,"value":"0.00000005"}}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := marshalWithUnit(tt.v, tt.unit)
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Backends an error can come from.
+const (
+	sourceCore     = "core"
+	sourceElectrum = "electrum"
+)
+
+// errNotFound is returned when something asked for does not exist, where the
+// backends do not say so with an error of their own.
+var errNotFound = errors.New("not found")
+
+// APIError is what every failed request gets back, as {"error": APIError}.
+// Code is one of the codes below, Source the backend that failed, if any.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Source  string `json:"source,omitempty"`
+}
+
+// errorCodes are the machine readable codes for each status the API uses.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "backend_error",
+	http.StatusServiceUnavailable:  "index_unavailable",
+	http.StatusGatewayTimeout:      "backend_timeout",
+}
+
+// backendError marks an error as coming from talking to a backend, for
+// failures that are not replies from it.
+type backendError struct {
+	source string
+	err    error
+}
+
+func (e *backendError) Error() string { return e.err.Error() }
+func (e *backendError) Unwrap() error { return e.err }
+
+// writeError writes an error response with the given status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeAPIError(w, status, APIError{Code: errorCodes[status], Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, apiErr APIError) {
+	resJSON, _ := json.Marshal(struct {
+		Error APIError `json:"error"`
+	}{apiErr})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resJSON)
+}
+
+// codeBackendUnavailable is the code of a 503 for a backend that is up but not
+// ready to answer yet, which is not the index being unavailable.
+const codeBackendUnavailable = "backend_unavailable"
+
+// writeBackendError writes the error response for a failed lookup of what,
+// such as "transaction". Things the backend does not know are 404s, a node
+// still starting up a 503, timeouts 504s and any other failure a 502.
+func writeBackendError(w http.ResponseWriter, err error, what string) {
+	status, source := classifyError(err)
+	apiErr := APIError{Code: errorCodes[status], Source: source}
+	if status == http.StatusServiceUnavailable {
+		apiErr.Code = codeBackendUnavailable
+	}
+	if status == http.StatusNotFound {
+		apiErr.Message = strings.ToUpper(what[:1]) + what[1:] + " not found"
+	} else {
+		fmt.Println("Error:", err)
+		apiErr.Message = "Error getting " + what + ": " + err.Error()
+	}
+	writeAPIError(w, status, apiErr)
+}
+
+// classifyError returns the status for an error from the backends, and which
+// backend it came from.
+func classifyError(err error) (int, string) {
+	source := ""
+	var rpcErr *RPCError
+	var electrumErr *ElectrumError
+	var bErr *backendError
+	switch {
+	case errors.As(err, &rpcErr):
+		source = sourceCore
+	case errors.As(err, &electrumErr):
+		source = sourceElectrum
+	case errors.As(err, &bErr):
+		source = bErr.source
+	}
+
+	var netErr net.Error
+	switch {
+	case isNotFound(err):
+		return http.StatusNotFound, source
+	case isRPCError(err, rpcErrInWarmup):
+		return http.StatusServiceUnavailable, source
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return http.StatusGatewayTimeout, source
+	case source != "":
+		return http.StatusBadGateway, source
+	}
+	return http.StatusInternalServerError, source
+}
+
+// isNotFound reports whether a backend said it does not know what it was
+// asked for. Electrum servers pass on Core's error for unknown transactions
+// inside their own.
+func isNotFound(err error) bool {
+	if errors.Is(err, errNotFound) || isRPCError(err, rpcErrInvalidAddressOrKey) {
+		return true
+	}
+	var electrumErr *ElectrumError
+	return errors.As(err, &electrumErr) &&
+		strings.Contains(electrumErr.Message, "No such mempool or blockchain transaction")
+}
+
+// recoverPanics turns a panicking handler into a 500 response instead of a
+// dropped connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				fmt.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL, p, debug.Stack())
+				writeError(w, http.StatusInternalServerError, "Internal error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func notFoundReq(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "No such endpoint")
+}
+
+func methodNotAllowedReq(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// isHash reports whether s looks like a block hash or txid.
+func isHash(s string) bool {
+	_, ok := hashKey(0, s)
+	return ok
+}
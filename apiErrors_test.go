@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		desc   string
+		err    error
+		status int
+		source string
+	}{
+		{"unknown transaction", fmt.Errorf("getblock: %w", &RPCError{Code: rpcErrInvalidAddressOrKey}), http.StatusNotFound, sourceCore},
+		{"node warming up", fmt.Errorf("getblock: %w", &RPCError{Code: rpcErrInWarmup, Message: "Loading block index..."}), http.StatusServiceUnavailable, sourceCore},
+		{"other node error", fmt.Errorf("getblock: %w", &RPCError{Code: rpcErrInvalidParameter}), http.StatusBadGateway, sourceCore},
+		{"timeout", &backendError{sourceElectrum, fmt.Errorf("batch: %w", context.DeadlineExceeded)}, http.StatusGatewayTimeout, sourceElectrum},
+		{"not found", errNotFound, http.StatusNotFound, ""},
+		{"internal", errors.New("broken"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		status, source := classifyError(tt.err)
+		if status != tt.status || source != tt.source {
+			t.Errorf("%s: got %d from %q, want %d from %q", tt.desc, status, source, tt.status, tt.source)
+		}
+	}
+}
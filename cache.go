@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kinds of cached data. Each kind has its own hit and miss counters.
+const (
+	cacheTx        = "tx"
+	cacheBlock     = "block"
+	cacheFullTx    = "fulltx"
+	cacheFullBlock = "fullblock"
+)
+
+var cacheKinds = []string{cacheTx, cacheBlock, cacheFullTx, cacheFullBlock}
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	cost      int
+	blockHash string
+	expires   time.Time // zero for entries that only leave by eviction
+}
+
+// CacheCounters are the hit and miss counts of one kind of data.
+type CacheCounters struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// CacheStats is a snapshot of a cache's state.
+type CacheStats struct {
+	Entries int                      `json:"entries"`
+	Cost    int                      `json:"cost"`
+	MaxCost int                      `json:"maxcost"`
+	Kinds   map[string]CacheCounters `json:"kinds"`
+}
+
+// lruCache is a size limited cache that evicts the least recently used
+// entries first. The size of an entry is its cost, roughly the number of
+// transactions it holds, so a full block weighs as much as its transactions.
+//
+// Entries can be tagged with the hash of the block they come from, so that
+// everything from a block can be dropped when it is reorganised out of the
+// chain. Unconfirmed data is not tagged and expires after a short TTL instead.
+type lruCache struct {
+	mu             sync.Mutex
+	maxCost        int
+	cost           int
+	unconfirmedTTL time.Duration
+	ll             *list.List
+	items          map[string]*list.Element
+	byBlock        map[string]map[string]struct{}
+	counters       map[string]*CacheCounters
+}
+
+func newLRUCache(maxCost int, unconfirmedTTL time.Duration) *lruCache {
+	c := &lruCache{
+		maxCost:        maxCost,
+		unconfirmedTTL: unconfirmedTTL,
+		ll:             list.New(),
+		items:          make(map[string]*list.Element),
+		byBlock:        make(map[string]map[string]struct{}),
+		counters:       make(map[string]*CacheCounters),
+	}
+	for _, kind := range cacheKinds {
+		c.counters[kind] = &CacheCounters{}
+	}
+	return c
+}
+
+// get returns the value cached for key of the given kind, if there is one
+// and it has not expired.
+func (c *lruCache) get(kind, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key = kind + ":" + key
+	elem, ok := c.items[key]
+	if ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			c.ll.MoveToFront(elem)
+			c.counters[kind].Hits++
+			return entry.value, true
+		}
+		c.remove(elem)
+	}
+	c.counters[kind].Misses++
+	return nil, false
+}
+
+// add caches value for key. Values with a blockHash are kept until they are
+// evicted or the block is dropped; values without one are unconfirmed and
+// expire after the unconfirmed TTL.
+func (c *lruCache) add(kind, key string, value interface{}, cost int, blockHash string) {
+	if blockHash == "" && c.unconfirmedTTL <= 0 {
+		return
+	}
+	if cost < 1 {
+		cost = 1
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Too big to ever fit; caching it would only flush everything else.
+	if cost > c.maxCost {
+		return
+	}
+
+	key = kind + ":" + key
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+
+	entry := &cacheEntry{key: key, value: value, cost: cost, blockHash: blockHash}
+	if blockHash == "" {
+		entry.expires = time.Now().Add(c.unconfirmedTTL)
+	} else {
+		keys, ok := c.byBlock[blockHash]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.byBlock[blockHash] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.cost += cost
+
+	for c.cost > c.maxCost {
+		c.remove(c.ll.Back())
+	}
+}
+
+// dropBlock removes everything cached from the block with the given hash.
+func (c *lruCache) dropBlock(blockHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byBlock[blockHash] {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+	}
+	delete(c.byBlock, blockHash)
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.cost -= entry.cost
+	if entry.blockHash != "" {
+		keys := c.byBlock[entry.blockHash]
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.byBlock, entry.blockHash)
+		}
+	}
+}
+
+func (c *lruCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Entries: c.ll.Len(),
+		Cost:    c.cost,
+		MaxCost: c.maxCost,
+		Kinds:   make(map[string]CacheCounters, len(c.counters)),
+	}
+	for kind, counters := range c.counters {
+		stats.Kinds[kind] = *counters
+	}
+	return stats
+}
+
+func cacheStatsReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resJSON, err := json.Marshal(cc.cache.stats())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Error marshaling data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resJSON)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// chainNetworks is the registry of networks the explorer can serve, keyed by
+// chain and then by the network name used in the config.
+var chainNetworks = map[string]map[string]*chaincfg.Params{
+	"nmc": {
+		"mainnet": &nmcMainNetParams,
+		"testnet": &nmcTestNetParams,
+		"regtest": &nmcRegTestParams,
+	},
+	"btc": {
+		"mainnet":  &chaincfg.MainNetParams,
+		"testnet":  &chaincfg.TestNet3Params,
+		"testnet3": &chaincfg.TestNet3Params,
+		"regtest":  &chaincfg.RegressionNetParams,
+		"signet":   &chaincfg.SigNetParams,
+	},
+}
+
+// nmcRegTestRegisterNet stands in for the network magic of Namecoin's regtest
+// when it is registered with btcd. The real magic is the same as Bitcoin's
+// regtest, which btcd has already registered.
+const nmcRegTestRegisterNet wire.BitcoinNet = 0xdab5bffb
+
+func init() {
+	// btcd registers the Bitcoin networks itself. Registering a network is what
+	// makes btcutil accept its bech32 addresses, so Namecoin's regtest is
+	// registered under a magic of its own rather than left out as a duplicate.
+	for _, params := range chainNetworks["nmc"] {
+		if params == &nmcRegTestParams {
+			regtest := nmcRegTestParams
+			regtest.Net = nmcRegTestRegisterNet
+			params = &regtest
+		}
+		if err := chaincfg.Register(params); err != nil {
+			panic(fmt.Sprintf("failed to register namecoin %s: %v", params.Name, err))
+		}
+	}
+}
+
+// chainParams returns the parameters for the named network of a chain.
+func chainParams(chain string, network string) (*chaincfg.Params, error) {
+	params, ok := chainNetworks[chain][strings.ToLower(network)]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown network %q", chain, network)
+	}
+	return params, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestDecodeBech32Addresses(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	tests := []struct {
+		name   string
+		params *chaincfg.Params
+		prefix string
+	}{
+		{"nmc mainnet", &nmcMainNetParams, "nc1"},
+		{"nmc testnet", &nmcTestNetParams, "tn1"},
+		{"nmc regtest", &nmcRegTestParams, "ncrt1"},
+		{"btc regtest", &chaincfg.RegressionNetParams, "bcrt1"},
+	}
+	for _, tt := range tests {
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(hash, tt.params)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		encoded := addr.EncodeAddress()
+		if encoded[:len(tt.prefix)] != tt.prefix {
+			t.Errorf("%s: address %s does not start with %s", tt.name, encoded, tt.prefix)
+		}
+		decoded, err := btcutil.DecodeAddress(encoded, tt.params)
+		if err != nil {
+			t.Errorf("%s: decoding %s: %v", tt.name, encoded, err)
+			continue
+		}
+		if !decoded.IsForNet(tt.params) {
+			t.Errorf("%s: %s is not for its own network", tt.name, encoded)
+		}
+	}
+}
+
+func TestRegTestKeepsNetworkMagic(t *testing.T) {
+	if nmcRegTestParams.Net != chaincfg.RegressionNetParams.Net {
+		t.Errorf("namecoin regtest magic changed to %x", uint32(nmcRegTestParams.Net))
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	flags "github.com/jessevdk/go-flags"
+)
+
+const (
+	defaultConfigFile = "explorer.conf"
+	defaultListen     = ":8080"
+	defaultDataDir    = "data"
+	defaultRPCTimeout = 30 * time.Second
+
+	defaultElectrumConns       = 2
+	defaultElectrumTimeout     = 30 * time.Second
+	defaultElectrumHealthCheck = 30 * time.Second
+	defaultElectrumRetry       = 5 * time.Minute
+
+	defaultPrevoutWorkers = 8
+
+	defaultCacheSize           = 100000
+	defaultCacheUnconfirmedTTL = 30 * time.Second
+
+	defaultTipPoll = 10 * time.Second
+)
+
+// chainConfig holds the backend settings for a single chain. The same struct
+// is used for every chain, so defaults are filled in by defaultConfig rather
+// than with struct tags.
+type chainConfig struct {
+	Disable             bool          `long:"disable" ini-name:"disable" env:"DISABLE" description:"Disable the endpoints for this chain"`
+	Network             string        `long:"network" ini-name:"network" env:"NETWORK" description:"Network the backends run on (mainnet, testnet, regtest; signet for btc)"`
+	RPCHost             string        `long:"rpchost" ini-name:"rpchost" env:"RPCHOST" description:"Core RPC host:port"`
+	RPCUser             string        `long:"rpcuser" ini-name:"rpcuser" env:"RPCUSER" description:"Core RPC username"`
+	RPCPass             string        `long:"rpcpass" ini-name:"rpcpass" env:"RPCPASS" default-mask:"-" description:"Core RPC password"`
+	RPCCookie           string        `long:"rpccookie" ini-name:"rpccookie" env:"RPCCOOKIE" description:"Core RPC cookie file, used instead of rpcuser/rpcpass"`
+	RPCTimeout          time.Duration `long:"rpctimeout" ini-name:"rpctimeout" env:"RPCTIMEOUT" description:"Timeout for a single Core RPC request"`
+	RPCWallet           string        `long:"rpcwallet" ini-name:"rpcwallet" env:"RPCWALLET" description:"Core wallet to send requests to, e.g. bank for regtest"`
+	Electrum            []string      `long:"electrum" ini-name:"electrum" env:"ELECTRUM" env-delim:"," description:"Electrum server as [tcp://|ssl://]host:port, with ?pin=<SHA-256 of the certificate> to pin an ssl server's certificate; may be given several times"`
+	ElectrumConns       int           `long:"electrumconns" ini-name:"electrumconns" env:"ELECTRUMCONNS" description:"Number of connections to keep open to each Electrum server"`
+	ElectrumTimeout     time.Duration `long:"electrumtimeout" ini-name:"electrumtimeout" env:"ELECTRUMTIMEOUT" description:"Timeout for a single Electrum request"`
+	ElectrumHealthCheck time.Duration `long:"electrumhealthcheck" ini-name:"electrumhealthcheck" env:"ELECTRUMHEALTHCHECK" description:"Interval between health checks of each Electrum connection"`
+	ElectrumRetry       time.Duration `long:"electrumretry" ini-name:"electrumretry" env:"ELECTRUMRETRY" description:"How long an Electrum server that keeps failing is left out before it is tried again"`
+	PrevoutWorkers      int           `long:"prevoutworkers" ini-name:"prevoutworkers" env:"PREVOUTWORKERS" description:"Maximum number of concurrent Electrum batches used to resolve the inputs of a block"`
+	CacheSize           int           `long:"cachesize" ini-name:"cachesize" env:"CACHESIZE" description:"Size of the transaction and block cache, in transactions; a block counts as its transactions. 0 disables the cache"`
+	CacheUnconfirmedTTL time.Duration `long:"cacheunconfirmedttl" ini-name:"cacheunconfirmedttl" env:"CACHEUNCONFIRMEDTTL" description:"How long unconfirmed transactions are cached. 0 disables caching them"`
+	Index               bool          `long:"index" ini-name:"index" env:"INDEX" description:"Build a local index of the chain under datadir and answer requests from it"`
+	TipPoll             time.Duration `long:"tippoll" ini-name:"tippoll" env:"TIPPOLL" description:"Interval at which Core is checked for a new best block, in addition to Electrum's header notifications"`
+
+	// name is the chain's route prefix, params are resolved from Network and
+	// electrumServers parsed from Electrum, all during validation. The backend
+	// clients, the cache, the index and the tip follower are set up once the
+	// config is loaded.
+	name            string
+	params          *chaincfg.Params
+	electrumServers []electrumServerAddr
+	rpc             *rpcClient
+	electrum        *electrumClient
+	cache           *lruCache
+	index           *chainIndex
+	follower        *tipFollower
+}
+
+type config struct {
+	ConfigFile string `short:"C" long:"configfile" env:"EXPLORER_CONFIGFILE" description:"Path to configuration file"`
+	Listen     string `long:"listen" env:"EXPLORER_LISTEN" description:"Address for the HTTP API to listen on"`
+	DataDir    string `long:"datadir" env:"EXPLORER_DATADIR" description:"Directory to store the chain indexes in"`
+
+	NMC chainConfig `group:"Namecoin" namespace:"nmc" env-namespace:"NMC"`
+	BTC chainConfig `group:"Bitcoin" namespace:"btc" env-namespace:"BTC"`
+}
+
+// cfg is the active configuration, set once by loadConfig at startup.
+var cfg *config
+
+// defaultConfig returns the settings used when nothing else is specified.
+// They match the regtest setup the explorer was developed against.
+func defaultConfig() config {
+	return config{
+		ConfigFile: defaultConfigFile,
+		Listen:     defaultListen,
+		DataDir:    defaultDataDir,
+		NMC: chainConfig{
+			Network:             "regtest",
+			RPCHost:             "127.0.0.1:18443",
+			RPCUser:             "rpc",
+			RPCPass:             "rpc",
+			RPCTimeout:          defaultRPCTimeout,
+			Electrum:            []string{"127.0.0.1:50001"},
+			ElectrumConns:       defaultElectrumConns,
+			ElectrumTimeout:     defaultElectrumTimeout,
+			ElectrumHealthCheck: defaultElectrumHealthCheck,
+			ElectrumRetry:       defaultElectrumRetry,
+			PrevoutWorkers:      defaultPrevoutWorkers,
+			CacheSize:           defaultCacheSize,
+			CacheUnconfirmedTTL: defaultCacheUnconfirmedTTL,
+			TipPoll:             defaultTipPoll,
+		},
+		BTC: chainConfig{
+			Network:             "regtest",
+			RPCHost:             "127.0.0.1:18444",
+			RPCUser:             "rpc",
+			RPCPass:             "rpc",
+			RPCTimeout:          defaultRPCTimeout,
+			Electrum:            []string{"127.0.0.1:50002"},
+			ElectrumConns:       defaultElectrumConns,
+			ElectrumTimeout:     defaultElectrumTimeout,
+			ElectrumHealthCheck: defaultElectrumHealthCheck,
+			ElectrumRetry:       defaultElectrumRetry,
+			PrevoutWorkers:      defaultPrevoutWorkers,
+			CacheSize:           defaultCacheSize,
+			CacheUnconfirmedTTL: defaultCacheUnconfirmedTTL,
+			TipPoll:             defaultTipPoll,
+		},
+	}
+}
+
+// loadConfig builds the configuration from, in increasing order of
+// precedence: the defaults, the config file, environment variables and
+// command line flags. The result is validated before it is returned.
+func loadConfig() (*config, error) {
+	// Pre-parse the command line to find the config file.
+	preCfg := defaultConfig()
+	preParser := flags.NewParser(&preCfg, flags.HelpFlag|flags.IgnoreUnknown)
+	if _, err := preParser.Parse(); err != nil {
+		var e *flags.Error
+		if errors.As(err, &e) && e.Type == flags.ErrHelp {
+			fmt.Fprintln(os.Stdout, err)
+			os.Exit(0)
+		}
+		return nil, err
+	}
+
+	conf := defaultConfig()
+	parser := flags.NewParser(&conf, flags.Default)
+
+	err := flags.NewIniParser(parser).ParseFile(preCfg.ConfigFile)
+	if err != nil {
+		// A missing file is only an error when it was asked for explicitly.
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) || preCfg.ConfigFile != defaultConfigFile {
+			return nil, fmt.Errorf("error parsing config file: %v", err)
+		}
+	}
+
+	// go-flags treats environment variables as defaults, which the config file
+	// would override. Feed them back in as arguments ahead of the real command
+	// line so they beat the file but still lose to explicit flags.
+	args, err := envArgs(parser)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, os.Args[1:]...)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// envArgs returns a --long=value argument for every option that has its
+// environment variable set. Lists are split into one argument per value.
+// Booleans cannot take a value, so they become a bare --long when true and
+// are left out when false.
+func envArgs(parser *flags.Parser) ([]string, error) {
+	var args []string
+	var walk func(groups []*flags.Group) error
+	walk = func(groups []*flags.Group) error {
+		for _, g := range groups {
+			for _, opt := range g.Options() {
+				key := opt.EnvKeyWithNamespace()
+				if key == "" {
+					continue
+				}
+				val, ok := os.LookupEnv(key)
+				if !ok {
+					continue
+				}
+				if opt.Field().Type.Kind() == reflect.Bool {
+					on, err := strconv.ParseBool(val)
+					if err != nil {
+						return fmt.Errorf("invalid boolean %s=%q", key, val)
+					}
+					if on {
+						args = append(args, "--"+opt.LongNameWithNamespace())
+					}
+					continue
+				}
+				vals := []string{val}
+				if opt.EnvDefaultDelim != "" {
+					vals = strings.Split(val, opt.EnvDefaultDelim)
+				}
+				for _, v := range vals {
+					args = append(args, "--"+opt.LongNameWithNamespace()+"="+v)
+				}
+			}
+			if err := walk(g.Groups()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(parser.Groups())
+	return args, err
+}
+
+func (c *config) validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", c.Listen, err)
+	}
+
+	if c.NMC.Disable && c.BTC.Disable {
+		return fmt.Errorf("all chains are disabled")
+	}
+
+	if err := c.NMC.validate("nmc"); err != nil {
+		return err
+	}
+	if err := c.BTC.validate("btc"); err != nil {
+		return err
+	}
+
+	// Each chain needs its own backends, otherwise requests for one chain
+	// would silently be answered by the other.
+	if !c.NMC.Disable && !c.BTC.Disable {
+		if c.NMC.RPCHost == c.BTC.RPCHost {
+			return fmt.Errorf("nmc and btc share the rpchost %s", c.NMC.RPCHost)
+		}
+		for _, nmc := range c.NMC.electrumServers {
+			for _, btc := range c.BTC.electrumServers {
+				if nmc.addr == btc.addr {
+					return fmt.Errorf("nmc and btc share the electrum server %s", nmc.addr)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cc *chainConfig) validate(chain string) error {
+	cc.name = chain
+	if cc.Disable {
+		return nil
+	}
+
+	params, err := chainParams(chain, cc.Network)
+	if err != nil {
+		return err
+	}
+	cc.params = params
+
+	if _, _, err := net.SplitHostPort(cc.RPCHost); err != nil {
+		return fmt.Errorf("%s: invalid rpchost %q: %v", chain, cc.RPCHost, err)
+	}
+	if cc.RPCCookie != "" {
+		if _, err := os.Stat(cc.RPCCookie); err != nil {
+			return fmt.Errorf("%s: rpccookie: %v", chain, err)
+		}
+	} else if cc.RPCUser == "" || cc.RPCPass == "" {
+		return fmt.Errorf("%s: rpcuser and rpcpass or rpccookie must be set", chain)
+	}
+	if cc.RPCTimeout < 0 {
+		return fmt.Errorf("%s: rpctimeout must not be negative", chain)
+	}
+	if len(cc.Electrum) == 0 {
+		return fmt.Errorf("%s: at least one electrum server must be set", chain)
+	}
+	cc.electrumServers = nil
+	for _, s := range cc.Electrum {
+		addr, err := parseElectrumServer(s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid electrum server %q: %v", chain, s, err)
+		}
+		cc.electrumServers = append(cc.electrumServers, addr)
+	}
+	if cc.ElectrumConns < 1 {
+		return fmt.Errorf("%s: electrumconns must be at least 1", chain)
+	}
+	if cc.ElectrumTimeout < 0 {
+		return fmt.Errorf("%s: electrumtimeout must not be negative", chain)
+	}
+	if cc.ElectrumHealthCheck <= 0 {
+		return fmt.Errorf("%s: electrumhealthcheck must be positive", chain)
+	}
+	if cc.ElectrumRetry < 0 {
+		return fmt.Errorf("%s: electrumretry must not be negative", chain)
+	}
+	if cc.PrevoutWorkers < 1 {
+		return fmt.Errorf("%s: prevoutworkers must be at least 1", chain)
+	}
+	if cc.CacheSize < 0 {
+		return fmt.Errorf("%s: cachesize must not be negative", chain)
+	}
+	if cc.CacheUnconfirmedTTL < 0 {
+		return fmt.Errorf("%s: cacheunconfirmedttl must not be negative", chain)
+	}
+	if cc.TipPoll <= 0 {
+		return fmt.Errorf("%s: tippoll must be positive", chain)
+	}
+
+	return nil
+}
+
+// chains returns the enabled chains.
+func (c *config) chains() []*chainConfig {
+	var chains []*chainConfig
+	for _, cc := range []*chainConfig{&c.NMC, &c.BTC} {
+		if !cc.Disable {
+			chains = append(chains, cc)
+		}
+	}
+	return chains
+}
+
+// indexPath is where the chain's index is stored. Each network gets its own.
+func (cc *chainConfig) indexPath() string {
+	return filepath.Join(cfg.DataDir, cc.name, cc.Network)
+}
+
+// hasNames reports whether the chain has Namecoin names.
+func (cc *chainConfig) hasNames() bool {
+	return cc.name == "nmc"
+}
+
+// nameRules returns the name rules of the chain's network, nil if it has no
+// names.
+func (cc *chainConfig) nameRules() *nameRules {
+	if !cc.hasNames() {
+		return nil
+	}
+	return nmcNetworkNameRules[cc.params]
+}
+
+// rpcURL is the Core RPC endpoint, including the wallet path if one is set.
+func (cc *chainConfig) rpcURL() string {
+	url := "http://" + cc.RPCHost
+	if cc.RPCWallet != "" {
+		url += "/wallet/" + cc.RPCWallet
+	}
+	return url
+}
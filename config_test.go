@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+func parseEnvConfig(t *testing.T) (config, error) {
+	t.Helper()
+	conf := defaultConfig()
+	parser := flags.NewParser(&conf, flags.Default)
+	args, err := envArgs(parser)
+	if err != nil {
+		return conf, err
+	}
+	_, err = parser.ParseArgs(args)
+	return conf, err
+}
+
+func TestEnvArgsBool(t *testing.T) {
+	tests := []struct {
+		index, disable string
+		wantIndex      bool
+		wantDisable    bool
+	}{
+		{"1", "true", true, true},
+		{"true", "false", true, false},
+		{"0", "0", false, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("NMC_INDEX", tt.index)
+		t.Setenv("BTC_DISABLE", tt.disable)
+		conf, err := parseEnvConfig(t)
+		if err != nil {
+			t.Fatalf("NMC_INDEX=%s BTC_DISABLE=%s: %v", tt.index, tt.disable, err)
+		}
+		if conf.NMC.Index != tt.wantIndex || conf.BTC.Disable != tt.wantDisable {
+			t.Errorf("NMC_INDEX=%s BTC_DISABLE=%s: got index %v, disable %v",
+				tt.index, tt.disable, conf.NMC.Index, conf.BTC.Disable)
+		}
+	}
+}
+
+func TestEnvArgsInvalidBool(t *testing.T) {
+	t.Setenv("NMC_INDEX", "maybe")
+	if _, err := parseEnvConfig(t); err == nil {
+		t.Fatal("expected an error for NMC_INDEX=maybe")
+	}
+}
+
+func TestEnvArgsValues(t *testing.T) {
+	t.Setenv("NMC_RPCHOST", "10.0.0.1:8336")
+	t.Setenv("NMC_ELECTRUM", "a:1,b:2")
+	conf, err := parseEnvConfig(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.NMC.RPCHost != "10.0.0.1:8336" {
+		t.Errorf("got rpchost %q", conf.NMC.RPCHost)
+	}
+	if len(conf.NMC.Electrum) != 2 || conf.NMC.Electrum[0] != "a:1" || conf.NMC.Electrum[1] != "b:2" {
+		t.Errorf("got electrum %q", conf.NMC.Electrum)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Error codes returned by Bitcoin Core and Namecoin Core, see rpc/protocol.h.
+const (
+	rpcErrWallet              = -4 // also unknown names, for name_show
+	rpcErrInvalidAddressOrKey = -5 // unknown block or transaction
+	rpcErrInvalidParameter    = -8
+	rpcErrInWarmup            = -28 // still loading the block index at startup
+)
+
+// rpcTransport is shared by every Core client so connections are kept alive
+// and reused between requests.
+var rpcTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// RPCError is an error reported by Core in the "error" field of a reply.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// isRPCError reports whether err is a Core error with the given code.
+func isRPCError(err error, code int) bool {
+	var rpcErr *RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == code
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	ID     uint64          `json:"id"`
+}
+
+// rpcClient is a JSON-RPC client for a Bitcoin Core or Namecoin Core node.
+type rpcClient struct {
+	url        string
+	user       string
+	pass       string
+	cookieFile string
+	timeout    time.Duration
+	httpClient *http.Client
+	nextID     uint64
+}
+
+func newRPCClient(cc *chainConfig) *rpcClient {
+	return &rpcClient{
+		url:        cc.rpcURL(),
+		user:       cc.RPCUser,
+		pass:       cc.RPCPass,
+		cookieFile: cc.RPCCookie,
+		timeout:    cc.RPCTimeout,
+		httpClient: &http.Client{Transport: rpcTransport},
+	}
+}
+
+// credentials returns the user and password to authenticate with. The cookie
+// file is read on every call since Core writes a new one when it restarts.
+func (c *rpcClient) credentials() (string, string, error) {
+	if c.cookieFile == "" {
+		return c.user, c.pass, nil
+	}
+
+	cookie, err := os.ReadFile(c.cookieFile)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading RPC cookie: %v", err)
+	}
+	user, pass, ok := strings.Cut(strings.TrimSpace(string(cookie)), ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed RPC cookie file %s", c.cookieFile)
+	}
+	return user, pass, nil
+}
+
+// post sends a request body to Core and decodes the reply into out. A zero
+// timeout leaves the deadline entirely to ctx.
+func (c *rpcClient) post(ctx context.Context, body interface{}, out interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(reqJSON))
+	if err != nil {
+		return err
+	}
+
+	user, pass, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, pass)
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Core answers RPC errors with a non-200 status and a JSON body, but
+	// authentication failures come back with an empty body.
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("RPC authentication failed: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("RPC request failed: %s", resp.Status)
+		}
+		return fmt.Errorf("error decoding RPC response: %v", err)
+	}
+
+	return nil
+}
+
+// call makes a single RPC request and unmarshals the result into result,
+// which may be nil if the result is not needed.
+func (c *rpcClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	req := rpcRequest{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&c.nextID, 1),
+		Method:  method,
+		Params:  params,
+	}
+
+	var resp rpcResponse
+	if err := c.post(ctx, req, &resp); err != nil {
+		return &backendError{sourceCore, fmt.Errorf("%s: %w", method, err)}
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %w", method, resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return &backendError{sourceCore, fmt.Errorf("%s: error decoding result: %v", method, err)}
+	}
+
+	return nil
+}
+
+// rpcMaxBatch caps the number of calls sent in one HTTP request so a huge
+// block does not turn into a single enormous request and reply.
+const rpcMaxBatch = 500
+
+// rpcBatch collects calls that are sent to Core together as JSON-RPC batch
+// arrays. Replies are matched to their calls by id, since Core does not
+// promise to answer in order.
+type rpcBatch struct {
+	client  *rpcClient
+	reqs    []rpcRequest
+	results []interface{}
+}
+
+func (c *rpcClient) newBatch() *rpcBatch {
+	return &rpcBatch{client: c}
+}
+
+// add queues a call whose result will be unmarshalled into result by send.
+func (b *rpcBatch) add(method string, params []interface{}, result interface{}) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	b.reqs = append(b.reqs, rpcRequest{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&b.client.nextID, 1),
+		Method:  method,
+		Params:  params,
+	})
+	b.results = append(b.results, result)
+}
+
+// send makes the queued calls and fills in their results. The returned slice
+// holds the error of each call, in the order they were added. The returned
+// error is only set if the batch as a whole could not be sent.
+func (b *rpcBatch) send(ctx context.Context) ([]error, error) {
+	errs := make([]error, len(b.reqs))
+
+	for start := 0; start < len(b.reqs); start += rpcMaxBatch {
+		end := start + rpcMaxBatch
+		if end > len(b.reqs) {
+			end = len(b.reqs)
+		}
+		chunk := b.reqs[start:end]
+
+		var resps []rpcResponse
+		if err := b.client.post(ctx, chunk, &resps); err != nil {
+			return nil, &backendError{sourceCore, fmt.Errorf("batch of %d calls: %w", len(chunk), err)}
+		}
+
+		byID := make(map[uint64]rpcResponse, len(resps))
+		for _, resp := range resps {
+			byID[resp.ID] = resp
+		}
+
+		for i, req := range chunk {
+			idx := start + i
+			resp, ok := byID[req.ID]
+			switch {
+			case !ok:
+				errs[idx] = &backendError{sourceCore, fmt.Errorf("%s: no reply in batch", req.Method)}
+			case resp.Error != nil:
+				errs[idx] = fmt.Errorf("%s: %w", req.Method, resp.Error)
+			case b.results[idx] != nil:
+				if err := json.Unmarshal(resp.Result, b.results[idx]); err != nil {
+					errs[idx] = &backendError{sourceCore, fmt.Errorf("%s: error decoding result: %v", req.Method, err)}
+				}
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// firstError returns the first non-nil error of a batch.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlockHeaderData is a verbose getblockheader result.
+type BlockHeaderData struct {
+	Hash              string  `json:"hash"`
+	Confirmations     int     `json:"confirmations"`
+	Height            int     `json:"height"`
+	Version           int32   `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              int64   `json:"time"`
+	MedianTime        int64   `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	ChainWork         string  `json:"chainwork"`
+	NTx               int     `json:"nTx"`
+	PreviousBlockHash string  `json:"previousblockhash"`
+	NextBlockHash     string  `json:"nextblockhash"`
+}
+
+// MempoolEntry is the subset of a getmempoolentry result the explorer uses.
+// Time is when the node first saw the transaction.
+type MempoolEntry struct {
+	VSize int   `json:"vsize"`
+	Time  int64 `json:"time"`
+	Fees  struct {
+		Base float64 `json:"base"`
+	} `json:"fees"`
+}
+
+// NameInfo is a Namecoin name_show result, or an entry of name_history. The
+// name and value are in hex, as nameParams asks for them.
+type NameInfo struct {
+	Name          string `json:"name"`
+	NameEncoding  string `json:"name_encoding"`
+	Value         string `json:"value"`
+	ValueEncoding string `json:"value_encoding"`
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Address       string `json:"address"`
+	Height        int    `json:"height"`
+	ExpiresIn     int    `json:"expires_in"`
+	Expired       bool   `json:"expired"`
+}
+
+// getBlock returns a block with all of its transactions decoded.
+func (c *rpcClient) getBlock(ctx context.Context, hash string) (BlockData, error) {
+	var block BlockData
+	err := c.call(ctx, "getblock", []interface{}{hash, 2}, &block) // verbosity = 2 includes all transactions in block
+	return block, err
+}
+
+func (c *rpcClient) getBlockHash(ctx context.Context, height int) (string, error) {
+	var hash string
+	err := c.call(ctx, "getblockhash", []interface{}{height}, &hash)
+	return hash, err
+}
+
+func (c *rpcClient) getBlockCount(ctx context.Context) (int, error) {
+	var count int
+	err := c.call(ctx, "getblockcount", nil, &count)
+	return count, err
+}
+
+func (c *rpcClient) getBestBlockHash(ctx context.Context) (string, error) {
+	var hash string
+	err := c.call(ctx, "getbestblockhash", nil, &hash)
+	return hash, err
+}
+
+func (c *rpcClient) getBlockHeader(ctx context.Context, hash string) (BlockHeaderData, error) {
+	var header BlockHeaderData
+	err := c.call(ctx, "getblockheader", []interface{}{hash, true}, &header)
+	return header, err
+}
+
+// nameShow returns the current state of a Namecoin name. Unknown names are
+// an rpcErrWallet error.
+func (c *rpcClient) nameShow(ctx context.Context, name []byte) (NameInfo, error) {
+	var info NameInfo
+	err := c.call(ctx, "name_show", nameParams(name), &info)
+	return info, err
+}
+
+// nameHistory returns every state a Namecoin name has had, oldest first. The
+// node has to run with -namehistory.
+func (c *rpcClient) nameHistory(ctx context.Context, name []byte) ([]NameInfo, error) {
+	var history []NameInfo
+	err := c.call(ctx, "name_history", nameParams(name), &history)
+	return history, err
+}
+
+// nameParams asks for names and values in hex, the one encoding all of them
+// have, so the results are in hex too.
+func nameParams(name []byte) []interface{} {
+	options := map[string]string{"nameEncoding": nameEncodingHex, "valueEncoding": nameEncodingHex}
+	return []interface{}{hex.EncodeToString(name), options}
+}
+
+// getBlockHashes returns the hashes of the blocks at heights, in one batch.
+func (c *rpcClient) getBlockHashes(ctx context.Context, heights []int) ([]string, error) {
+	hashes := make([]string, len(heights))
+	batch := c.newBatch()
+	for i, height := range heights {
+		batch.add("getblockhash", []interface{}{height}, &hashes[i])
+	}
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hashes, firstError(errs)
+}
+
+// getBlocks returns the blocks with the given hashes, in one batch.
+func (c *rpcClient) getBlocks(ctx context.Context, hashes []string) ([]BlockData, error) {
+	blocks := make([]BlockData, len(hashes))
+	batch := c.newBatch()
+	for i, hash := range hashes {
+		batch.add("getblock", []interface{}{hash, 2}, &blocks[i])
+	}
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return blocks, firstError(errs)
+}
+
+// getBlockHeaders returns the headers of the blocks with the given hashes, in
+// one batch.
+func (c *rpcClient) getBlockHeaders(ctx context.Context, hashes []string) ([]BlockHeaderData, error) {
+	headers := make([]BlockHeaderData, len(hashes))
+	batch := c.newBatch()
+	for i, hash := range hashes {
+		batch.add("getblockheader", []interface{}{hash, true}, &headers[i])
+	}
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return headers, firstError(errs)
+}
+
+// getMempoolEntries returns the mempool entries of the given transactions, in
+// one batch. Transactions no longer in the mempool are left out.
+func (c *rpcClient) getMempoolEntries(ctx context.Context, txids []string) (map[string]MempoolEntry, error) {
+	entries := make([]MempoolEntry, len(txids))
+	batch := c.newBatch()
+	for i, txid := range txids {
+		batch.add("getmempoolentry", []interface{}{txid}, &entries[i])
+	}
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]MempoolEntry, len(txids))
+	for i, txid := range txids {
+		if errs[i] == nil {
+			res[txid] = entries[i]
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	electrumClientName      = "block-explorer"
+	electrumProtocolVersion = "1.4"
+
+	electrumDialTimeout = 10 * time.Second
+	electrumMinBackoff  = time.Second
+	electrumMaxBackoff  = time.Minute
+
+	// electrumMaxAttempts is how often a request is sent before giving up
+	// when the connection it went out on is lost.
+	electrumMaxAttempts = 3
+
+	// electrumMaxBatch caps the size of one batch so a single request stays
+	// well inside the server's per-request cost limits.
+	electrumMaxBatch = 100
+)
+
+// errElectrumNotConnected is returned for calls made while no connection to
+// the server is up.
+var errElectrumNotConnected = errors.New("not connected to electrum server")
+
+// notConnected returns errElectrumNotConnected, or ctx's error if it is done,
+// so a call that ran out of time is reported as a timeout and not retried.
+func notConnected(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", err, errElectrumNotConnected)
+	}
+	return errElectrumNotConnected
+}
+
+// ElectrumError is an error reported by the Electrum server for a request.
+type ElectrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ElectrumError) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+type electrumRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// electrumMessage is anything the server sends: a reply to a request or a
+// notification, which has a method but no id.
+type electrumMessage struct {
+	ID     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *ElectrumError  `json:"error"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// electrumSubscription is replayed on every new connection so notifications
+// keep coming after a reconnect.
+type electrumSubscription struct {
+	method  string
+	params  []interface{}
+	handler func(params json.RawMessage)
+}
+
+// electrumClient keeps a small pool of long-lived connections to each of a
+// chain's Electrum servers. Requests are spread over the connections of all
+// healthy servers and any number of them can be in flight at once; replies
+// are routed back to their callers by id.
+type electrumClient struct {
+	servers     []*electrumServer
+	conns       []*electrumConn
+	timeout     time.Duration
+	healthCheck time.Duration
+	next        uint32
+	nextID      uint64
+
+	// readyCh is closed and replaced whenever a connection comes up, to wake
+	// requests waiting for one.
+	readyMu sync.Mutex
+	readyCh chan struct{}
+
+	// Subscriptions all go over a single connection, subConn, so every
+	// notification arrives once. They move to another connection when it is
+	// lost.
+	subsMu  sync.Mutex
+	subs    []electrumSubscription
+	subConn *electrumConn
+}
+
+func newElectrumClient(cc *chainConfig) *electrumClient {
+	c := &electrumClient{
+		timeout:     cc.ElectrumTimeout,
+		healthCheck: cc.ElectrumHealthCheck,
+		readyCh:     make(chan struct{}),
+	}
+	for _, addr := range cc.electrumServers {
+		server := &electrumServer{
+			electrumServerAddr: addr,
+			tls:                addr.tlsConfig(),
+			retry:              cc.ElectrumRetry,
+		}
+		for i := 0; i < cc.ElectrumConns; i++ {
+			conn := &electrumConn{
+				client:  c,
+				server:  server,
+				pending: make(map[uint64]chan electrumMessage),
+			}
+			server.conns = append(server.conns, conn)
+			c.conns = append(c.conns, conn)
+		}
+		c.servers = append(c.servers, server)
+	}
+	for _, conn := range c.conns {
+		go conn.run()
+	}
+	return c
+}
+
+// call sends a request on the next connected connection and unmarshals the
+// result into result, which may be nil.
+func (c *electrumClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	msgs, err := c.roundTrip(ctx, []electrumCall{{method: method, params: params}}, false)
+	if err != nil {
+		return &backendError{sourceElectrum, fmt.Errorf("%s: %w", method, err)}
+	}
+	msg := msgs[0]
+	if msg.Error != nil {
+		return fmt.Errorf("%s: %w", method, msg.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Result, result); err != nil {
+		return &backendError{sourceElectrum, fmt.Errorf("%s: error decoding result: %v", method, err)}
+	}
+	return nil
+}
+
+// roundTrip sends calls on the next connected connection, waiting for one to
+// come up if there is none. If the connection is lost before the replies
+// arrive, the calls are sent again on another one.
+func (c *electrumClient) roundTrip(ctx context.Context, calls []electrumCall, asBatch bool) ([]electrumMessage, error) {
+	var err error
+	for attempt := 0; attempt < electrumMaxAttempts; attempt++ {
+		var conn *electrumConn
+		conn, err = c.waitPick(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var msgs []electrumMessage
+		msgs, err = conn.roundTripBatch(ctx, calls, asBatch)
+		if !errors.Is(err, errElectrumNotConnected) {
+			return msgs, err
+		}
+	}
+	return nil, err
+}
+
+// electrumCall is one request of a batch.
+type electrumCall struct {
+	method string
+	params []interface{}
+}
+
+// electrumBatch collects requests that are sent to the server as JSON-RPC
+// batch arrays.
+type electrumBatch struct {
+	client  *electrumClient
+	calls   []electrumCall
+	results []interface{}
+}
+
+func (c *electrumClient) newBatch() *electrumBatch {
+	return &electrumBatch{client: c}
+}
+
+// add queues a request whose result will be unmarshalled into result by send.
+func (b *electrumBatch) add(method string, params []interface{}, result interface{}) {
+	b.calls = append(b.calls, electrumCall{method: method, params: params})
+	b.results = append(b.results, result)
+}
+
+// send makes the queued requests and fills in their results. The returned
+// slice holds the error of each request, in the order they were added. The
+// returned error is only set if the batch as a whole failed.
+func (b *electrumBatch) send(ctx context.Context) ([]error, error) {
+	c := b.client
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	errs := make([]error, len(b.calls))
+	for start := 0; start < len(b.calls); start += electrumMaxBatch {
+		end := start + electrumMaxBatch
+		if end > len(b.calls) {
+			end = len(b.calls)
+		}
+
+		msgs, err := c.roundTrip(ctx, b.calls[start:end], true)
+		if err != nil {
+			return nil, &backendError{sourceElectrum, fmt.Errorf("batch of %d requests: %w", end-start, err)}
+		}
+
+		for i, msg := range msgs {
+			idx := start + i
+			method := b.calls[idx].method
+			switch {
+			case msg.Error != nil:
+				errs[idx] = fmt.Errorf("%s: %w", method, msg.Error)
+			case b.results[idx] != nil:
+				if err := json.Unmarshal(msg.Result, b.results[idx]); err != nil {
+					errs[idx] = &backendError{sourceElectrum, fmt.Errorf("%s: error decoding result: %v", method, err)}
+				}
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// subscribe registers handler for notifications of method and sends the
+// subscription, now and whenever the subscriptions move to a new connection.
+// The handler is also called with the state returned by each subscription
+// request.
+func (c *electrumClient) subscribe(method string, params []interface{}, handler func(params json.RawMessage)) {
+	sub := electrumSubscription{method: method, params: params, handler: handler}
+
+	c.subsMu.Lock()
+	c.subs = append(c.subs, sub)
+	conn := c.subConn
+	c.subsMu.Unlock()
+
+	if conn != nil {
+		go conn.sendSubscription(sub)
+	}
+}
+
+// notify passes a notification to every handler subscribed to its method.
+func (c *electrumClient) notify(method string, params json.RawMessage) {
+	c.subsMu.Lock()
+	subs := append([]electrumSubscription(nil), c.subs...)
+	c.subsMu.Unlock()
+
+	for _, sub := range subs {
+		if sub.method == method {
+			sub.handler(params)
+		}
+	}
+}
+
+// connReady is called when conn has come up. It wakes waiting requests and
+// gives conn the subscriptions if no other connection has them.
+func (c *electrumClient) connReady(conn *electrumConn) {
+	c.readyMu.Lock()
+	close(c.readyCh)
+	c.readyCh = make(chan struct{})
+	c.readyMu.Unlock()
+
+	c.subsMu.Lock()
+	if c.subConn != nil {
+		c.subsMu.Unlock()
+		return
+	}
+	c.subConn = conn
+	subs := append([]electrumSubscription(nil), c.subs...)
+	c.subsMu.Unlock()
+
+	for _, sub := range subs {
+		go conn.sendSubscription(sub)
+	}
+}
+
+// connLost is called when conn has gone down. If it carried the
+// subscriptions, they move to another connection.
+func (c *electrumClient) connLost(conn *electrumConn) {
+	c.subsMu.Lock()
+	if c.subConn != conn {
+		c.subsMu.Unlock()
+		return
+	}
+	c.subConn = nil
+	c.subsMu.Unlock()
+
+	if next := c.pick(); next != nil {
+		c.connReady(next)
+	}
+}
+
+// pick returns the next connected connection of a healthy server in
+// round-robin order.
+func (c *electrumClient) pick() *electrumConn {
+	n := len(c.conns)
+	start := int(atomic.AddUint32(&c.next, 1))
+	for i := 0; i < n; i++ {
+		conn := c.conns[(start+i)%n]
+		if conn.connected() && conn.server.healthy() {
+			return conn
+		}
+	}
+	return nil
+}
+
+// waitPick is like pick, but waits for a connection to come up if there is
+// none.
+func (c *electrumClient) waitPick(ctx context.Context) (*electrumConn, error) {
+	for {
+		// Take the channel before looking so a connection coming up in
+		// between is not missed.
+		c.readyMu.Lock()
+		ready := c.readyCh
+		c.readyMu.Unlock()
+
+		if conn := c.pick(); conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, notConnected(ctx)
+		}
+	}
+}
+
+// electrumConn is one connection of the pool. It reconnects by itself, with
+// exponential backoff, whenever the connection is lost.
+type electrumConn struct {
+	client *electrumClient
+	server *electrumServer
+
+	mu      sync.Mutex // guards conn, ready and pending
+	conn    net.Conn
+	ready   bool // set once the version has been negotiated
+	pending map[uint64]chan electrumMessage
+
+	writeMu sync.Mutex
+}
+
+func (ec *electrumConn) connected() bool {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.ready
+}
+
+// run connects, serves the connection until it fails and starts over. While
+// the server is dropped it waits for the retry period to end.
+func (ec *electrumConn) run() {
+	backoff := electrumMinBackoff
+	for {
+		if wait := ec.server.retryIn(); wait > 0 {
+			time.Sleep(wait)
+			backoff = electrumMinBackoff
+		}
+
+		conn, err := ec.server.dial()
+		if err != nil {
+			fmt.Println("Error connecting to electrum server:", err)
+			ec.server.failed(err)
+		} else if ec.serve(conn) {
+			backoff = electrumMinBackoff
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > electrumMaxBackoff {
+			backoff = electrumMaxBackoff
+		}
+	}
+}
+
+// serve uses conn until it fails. It reports whether the connection got as
+// far as a successful version negotiation.
+func (ec *electrumConn) serve(conn net.Conn) bool {
+	ec.mu.Lock()
+	ec.conn = conn
+	ec.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ec.readLoop(conn)
+		close(done)
+	}()
+
+	// server.version has to be the first message on a connection.
+	var version []string
+	ctx, cancel := context.WithTimeout(context.Background(), electrumDialTimeout)
+	msg, err := ec.roundTrip(ctx, "server.version", []interface{}{electrumClientName, electrumProtocolVersion})
+	cancel()
+	if err == nil && msg.Error != nil {
+		err = msg.Error
+	}
+	if err == nil {
+		err = json.Unmarshal(msg.Result, &version)
+	}
+	if err != nil {
+		fmt.Println("Error negotiating electrum protocol version:", err)
+		conn.Close()
+		<-done
+		ec.server.failed(err)
+		return false
+	}
+	fmt.Println("Connected to electrum server", ec.server, version)
+	ec.server.succeeded()
+
+	ec.mu.Lock()
+	ec.ready = true
+	ec.mu.Unlock()
+	ec.client.connReady(ec)
+
+	// Check the server regularly; this also keeps the connection alive, as
+	// servers drop idle clients.
+	ticker := time.NewTicker(ec.client.healthCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			ec.client.connLost(ec)
+			return true
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), electrumDialTimeout)
+			_, err := ec.roundTrip(ctx, "server.ping", nil)
+			cancel()
+			if errors.Is(err, errElectrumNotConnected) {
+				// Already lost; done is about to fire.
+				continue
+			}
+			if err != nil {
+				fmt.Println("Electrum health check failed:", err)
+				conn.Close()
+				ec.server.failed(err)
+			} else {
+				ec.server.succeeded()
+			}
+		}
+	}
+}
+
+// close drops the current connection, if any. run opens a new one.
+func (ec *electrumConn) close() {
+	ec.mu.Lock()
+	conn := ec.conn
+	ec.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// readLoop dispatches everything read from conn until it fails, then fails
+// all requests still waiting for a reply.
+func (ec *electrumConn) readLoop(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			fmt.Println("Error reading from electrum server:", err)
+			break
+		}
+		ec.dispatch(line)
+	}
+
+	conn.Close()
+	ec.mu.Lock()
+	ec.conn = nil
+	ec.ready = false
+	pending := ec.pending
+	ec.pending = make(map[uint64]chan electrumMessage)
+	ec.mu.Unlock()
+
+	for _, ch := range pending {
+		close(ch)
+	}
+}
+
+func (ec *electrumConn) dispatch(line []byte) {
+	// Replies to a batch arrive together as one array.
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '[' {
+		var msgs []json.RawMessage
+		if err := json.Unmarshal(trimmed, &msgs); err != nil {
+			fmt.Println("Error decoding electrum batch reply:", err)
+			return
+		}
+		for _, msg := range msgs {
+			ec.dispatch(msg)
+		}
+		return
+	}
+
+	var msg electrumMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		fmt.Println("Error decoding electrum message:", err)
+		return
+	}
+
+	if msg.ID == nil {
+		if msg.Method != "" {
+			ec.client.notify(msg.Method, msg.Params)
+		}
+		return
+	}
+
+	ec.mu.Lock()
+	ch, ok := ec.pending[*msg.ID]
+	delete(ec.pending, *msg.ID)
+	ec.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// roundTrip sends a request and waits for its reply.
+func (ec *electrumConn) roundTrip(ctx context.Context, method string, params []interface{}) (electrumMessage, error) {
+	msgs, err := ec.roundTripBatch(ctx, []electrumCall{{method: method, params: params}}, false)
+	if err != nil {
+		return electrumMessage{}, err
+	}
+	return msgs[0], nil
+}
+
+// roundTripBatch sends calls and waits for all of their replies, which come
+// back in the order of calls. If asBatch is set the calls go out as a single
+// JSON-RPC batch array.
+func (ec *electrumConn) roundTripBatch(ctx context.Context, calls []electrumCall, asBatch bool) ([]electrumMessage, error) {
+	reqs := make([]electrumRequest, len(calls))
+	for i, call := range calls {
+		params := call.params
+		if params == nil {
+			params = []interface{}{}
+		}
+		reqs[i] = electrumRequest{
+			JSONRPC: "2.0",
+			ID:      atomic.AddUint64(&ec.client.nextID, 1),
+			Method:  call.method,
+			Params:  params,
+		}
+	}
+
+	var reqJSON []byte
+	var err error
+	if asBatch {
+		reqJSON, err = json.Marshal(reqs)
+	} else {
+		reqJSON, err = json.Marshal(reqs[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Buffered so dispatch never blocks on a caller that already gave up.
+	chans := make([]chan electrumMessage, len(reqs))
+	ec.mu.Lock()
+	conn := ec.conn
+	if conn == nil {
+		ec.mu.Unlock()
+		return nil, notConnected(ctx)
+	}
+	for i, req := range reqs {
+		chans[i] = make(chan electrumMessage, 1)
+		ec.pending[req.ID] = chans[i]
+	}
+	ec.mu.Unlock()
+
+	forget := func() {
+		ec.mu.Lock()
+		for _, req := range reqs {
+			delete(ec.pending, req.ID)
+		}
+		ec.mu.Unlock()
+	}
+
+	if err := ec.write(ctx, conn, append(reqJSON, '\n')); err != nil {
+		forget()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		// write closed the connection, so the calls can be retried elsewhere.
+		return nil, fmt.Errorf("%w: %v", errElectrumNotConnected, err)
+	}
+
+	msgs := make([]electrumMessage, len(reqs))
+	for i, ch := range chans {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil, notConnected(ctx)
+			}
+			msgs[i] = msg
+		case <-ctx.Done():
+			forget()
+			return nil, ctx.Err()
+		}
+	}
+	return msgs, nil
+}
+
+func (ec *electrumConn) write(ctx context.Context, conn net.Conn, data []byte) error {
+	ec.writeMu.Lock()
+	defer ec.writeMu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err := conn.Write(data); err != nil {
+		// A partial write leaves the stream unusable.
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (ec *electrumConn) sendSubscription(sub electrumSubscription) {
+	ctx, cancel := context.WithTimeout(context.Background(), electrumDialTimeout)
+	defer cancel()
+
+	msg, err := ec.roundTrip(ctx, sub.method, sub.params)
+	if err == nil && msg.Error != nil {
+		err = msg.Error
+	}
+	if err != nil {
+		fmt.Println("Error subscribing to", sub.method, ":", err)
+		return
+	}
+	// The reply carries the current state. Notifications repeat the request
+	// params followed by the new state, so hand it over in the same shape.
+	params := append(append([]interface{}(nil), sub.params...), msg.Result)
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	sub.handler(paramsJSON)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestElectrumTimeoutWithoutConnection(t *testing.T) {
+	c := &electrumClient{readyCh: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	batch := c.newBatch()
+	batch.add("server.ping", nil, nil)
+	_, err := batch.send(ctx)
+	if status, source := classifyError(err); status != http.StatusGatewayTimeout || source != sourceElectrum {
+		t.Errorf("got status %d from %q for %v, want %d from %q", status, source, err, http.StatusGatewayTimeout, sourceElectrum)
+	}
+}
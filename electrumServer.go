@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// electrumMaxFailures is the number of failures in a row after which a server
+// is taken out of rotation.
+const electrumMaxFailures = 3
+
+// electrumServerAddr is a parsed electrum server option.
+type electrumServerAddr struct {
+	addr   string
+	useTLS bool
+	pin    []byte // SHA-256 of the server's certificate, if pinned
+}
+
+func (a electrumServerAddr) String() string {
+	if a.useTLS {
+		return "ssl://" + a.addr
+	}
+	return "tcp://" + a.addr
+}
+
+// parseElectrumServer parses a server given as [tcp://|ssl://]host:port, with
+// an optional ?pin=<hex SHA-256 of the certificate> for ssl servers. A pinned
+// certificate is trusted even if it is self-signed, as is common for
+// ElectrumX.
+func parseElectrumServer(s string) (electrumServerAddr, error) {
+	if !strings.Contains(s, "://") {
+		s = "tcp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return electrumServerAddr{}, err
+	}
+
+	var a electrumServerAddr
+	switch u.Scheme {
+	case "tcp":
+	case "ssl", "tls":
+		a.useTLS = true
+	default:
+		return electrumServerAddr{}, fmt.Errorf("unknown scheme %q, expected tcp or ssl", u.Scheme)
+	}
+	if u.Path != "" || u.User != nil || u.Fragment != "" {
+		return electrumServerAddr{}, fmt.Errorf("expected [tcp://|ssl://]host:port")
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return electrumServerAddr{}, err
+	}
+	a.addr = u.Host
+
+	query := u.Query()
+	for key := range query {
+		if key != "pin" {
+			return electrumServerAddr{}, fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+	if pin := query.Get("pin"); pin != "" {
+		if !a.useTLS {
+			return electrumServerAddr{}, fmt.Errorf("pin is only valid for ssl servers")
+		}
+		// Accept fingerprints as printed by openssl, with colons.
+		a.pin, err = hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err != nil || len(a.pin) != sha256.Size {
+			return electrumServerAddr{}, fmt.Errorf("pin must be a hex SHA-256 fingerprint")
+		}
+	}
+
+	return a, nil
+}
+
+// tlsConfig returns the TLS settings for the server, or nil for plain TCP.
+func (a electrumServerAddr) tlsConfig() *tls.Config {
+	if !a.useTLS {
+		return nil
+	}
+
+	host, _, _ := net.SplitHostPort(a.addr)
+	conf := &tls.Config{ServerName: host}
+	if a.pin != nil {
+		// The pin replaces the usual chain verification.
+		conf.InsecureSkipVerify = true
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("no certificate presented")
+			}
+			fingerprint := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(fingerprint[:], a.pin) {
+				return fmt.Errorf("certificate fingerprint %x does not match pin", fingerprint)
+			}
+			return nil
+		}
+	}
+	return conf
+}
+
+// electrumServer is one of a chain's Electrum servers, with its own pool of
+// connections. A server that keeps failing is dropped for a while and then
+// tried again.
+type electrumServer struct {
+	electrumServerAddr
+	tls   *tls.Config
+	retry time.Duration
+	conns []*electrumConn
+
+	mu        sync.Mutex // guards failures and downUntil
+	failures  int
+	downUntil time.Time
+}
+
+func (s *electrumServer) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: electrumDialTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.addr, s.tls)
+	}
+	return dialer.Dial("tcp", s.addr)
+}
+
+// healthy reports whether the server is in rotation.
+func (s *electrumServer) healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !time.Now().Before(s.downUntil)
+}
+
+// retryIn returns how long a dropped server has left before it is tried
+// again.
+func (s *electrumServer) retryIn() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Until(s.downUntil)
+}
+
+func (s *electrumServer) succeeded() {
+	s.mu.Lock()
+	s.failures = 0
+	s.mu.Unlock()
+}
+
+// failed records a failed connection attempt or health check. Once there
+// have been too many in a row the server is dropped: its connections are
+// closed and not opened again until the retry period is over.
+func (s *electrumServer) failed(err error) {
+	s.mu.Lock()
+	s.failures++
+	drop := s.failures >= electrumMaxFailures && !time.Now().Before(s.downUntil)
+	if drop {
+		s.failures = 0
+		s.downUntil = time.Now().Add(s.retry)
+	}
+	s.mu.Unlock()
+
+	if !drop {
+		return
+	}
+	fmt.Println("Dropping electrum server", s, "for", s.retry, "after repeated failures:", err)
+	for _, conn := range s.conns {
+		conn.close()
+	}
+}
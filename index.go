@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key layout of the index. Hashes are stored as raw bytes in the order they
+// are displayed, heights and output indexes as big endian uint32 so that keys
+// sort by them.
+var (
+	idxVersionKey = []byte("V") // V -> indexVersion
+	idxTipKey     = []byte("T") // T -> indexTip
+)
+
+const (
+	idxHeightPrefix = 'h' // h<height> -> block hash
+	idxBlockPrefix  = 'b' // b<block hash> -> indexedBlock
+	idxTxPrefix     = 'x' // x<txid> -> indexedTx
+	idxOutPrefix    = 'o' // o<txid><vout> -> indexedOutput
+	idxAddrPrefix   = 'a' // a<scripthash><height><txid> -> balance change in satoshis
+	idxSpendPrefix  = 's' // s<txid><vout> -> indexedSpend
+
+	// Namecoin only
+	idxNamePrefix       = 'n' // n<name> -> indexedName
+	idxNameHistPrefix   = 'm' // m<sha256(name)><height><tx position><vout> -> indexedNameOp
+	idxNameExpiryPrefix = 'e' // e<expiry height><name> -> nothing, to list names by expiry
+)
+
+// indexVersion is bumped whenever the layout changes in a way that needs the
+// index to be rebuilt.
+const indexVersion = 9
+
+// indexTip is the last block in the index.
+type indexTip struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// indexedBlock is a block without its transactions, which are stored on
+// their own.
+type indexedBlock struct {
+	Block BlockData `json:"block"`
+	TxIDs []string  `json:"txids"`
+}
+
+// indexedTx is a confirmed transaction with its inputs already resolved.
+type indexedTx struct {
+	Tx       ElectrumTransaction `json:"tx"`
+	Height   int                 `json:"height"`
+	Prevouts []FullVin           `json:"prevouts"`
+}
+
+// indexedHistTx is a confirmed transaction in the history of a script, with
+// the change it made to the script's balance.
+type indexedHistTx struct {
+	HistoryTransaction
+	Delta Amount
+}
+
+// indexedSpend is the input spending an output.
+type indexedSpend struct {
+	TxID   string `json:"txid"`
+	Vin    int    `json:"vin"`
+	Height int    `json:"height"`
+}
+
+// indexedOutput is what is needed of an output to resolve an input spending
+// it.
+type indexedOutput struct {
+	Value   Amount `json:"value"`
+	Address string `json:"address"`
+	Script  string `json:"script"`
+}
+
+// chainIndex is a local copy of a chain in a leveldb database, written by the
+// indexer and read by the handlers. All of its read methods can be called on
+// a nil index, which has nothing in it, so callers don't need to check
+// whether indexing is enabled.
+type chainIndex struct {
+	db   *leveldb.DB
+	cc   *chainConfig
+	wake chan struct{}
+
+	tipMu sync.RWMutex
+	tip   indexTip
+
+	// chainHeight is the height of the node's best block as last seen by the
+	// indexer.
+	chainHeight int64
+}
+
+func openIndex(path string, cc *chainConfig) (*chainIndex, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening index: %v", err)
+	}
+
+	idx := &chainIndex{
+		db:   db,
+		cc:   cc,
+		wake: make(chan struct{}, 1),
+		tip:  indexTip{Height: -1},
+	}
+	if _, err := idx.get(idxTipKey, &idx.tip); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := idx.checkVersion(path); err != nil {
+		db.Close()
+		return nil, err
+	}
+	idx.chainHeight = int64(idx.tip.Height)
+	return idx, nil
+}
+
+// checkVersion makes sure an existing index has the current layout, and
+// marks a new one as having it.
+func (idx *chainIndex) checkVersion(path string) error {
+	var version int
+	found, err := idx.get(idxVersionKey, &version)
+	if err != nil {
+		return err
+	}
+	if found && version == indexVersion {
+		return nil
+	}
+	if found || idx.tip.Height >= 0 {
+		return fmt.Errorf("index at %s has an old format, delete it to rebuild it", path)
+	}
+
+	data, _ := json.Marshal(indexVersion)
+	return idx.db.Put(idxVersionKey, data, nil)
+}
+
+// get reads the JSON value at key into v and reports whether it was there.
+func (idx *chainIndex) get(key []byte, v interface{}) (bool, error) {
+	data, err := idx.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("corrupt index entry %x: %v", key, err)
+	}
+	return true, nil
+}
+
+// lookup is get for the handlers, which fall back to the backends when the
+// index can't answer.
+func (idx *chainIndex) lookup(key []byte, v interface{}) bool {
+	if idx == nil {
+		return false
+	}
+	found, err := idx.get(key, v)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	return found
+}
+
+func (idx *chainIndex) currentTip() indexTip {
+	if idx == nil {
+		return indexTip{Height: -1}
+	}
+	idx.tipMu.RLock()
+	defer idx.tipMu.RUnlock()
+	return idx.tip
+}
+
+// synced reports whether the index has caught up with the node, so that
+// anything not in it does not exist in the chain either.
+func (idx *chainIndex) synced() bool {
+	if idx == nil {
+		return false
+	}
+	tip := idx.currentTip()
+	return tip.Height >= 0 && int64(tip.Height) >= atomic.LoadInt64(&idx.chainHeight)
+}
+
+// confirmations returns the confirmation count of a block at height.
+func (idx *chainIndex) confirmations(height int) int {
+	return int(atomic.LoadInt64(&idx.chainHeight)) - height + 1
+}
+
+func (idx *chainIndex) blockHash(height int) (string, bool) {
+	if idx == nil || height < 0 {
+		return "", false
+	}
+	hash, err := idx.db.Get(heightKey(height), nil)
+	if err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(hash), true
+}
+
+func (idx *chainIndex) block(hash string) (indexedBlock, bool) {
+	key, ok := hashKey(idxBlockPrefix, hash)
+	if !ok {
+		return indexedBlock{}, false
+	}
+	var block indexedBlock
+	if !idx.lookup(key, &block) {
+		return indexedBlock{}, false
+	}
+	block.Block.Confirmations = float64(idx.confirmations(int(block.Block.Height)))
+	return block, true
+}
+
+func (idx *chainIndex) tx(txid string) (indexedTx, bool) {
+	key, ok := hashKey(idxTxPrefix, txid)
+	if !ok {
+		return indexedTx{}, false
+	}
+	var tx indexedTx
+	if !idx.lookup(key, &tx) {
+		return indexedTx{}, false
+	}
+	tx.Tx.Confirmations = idx.confirmations(tx.Height)
+	return tx, true
+}
+
+// history returns the confirmed transactions involving the script with the
+// given Electrum scripthash, by ascending height.
+func (idx *chainIndex) history(scriptHash string) ([]indexedHistTx, error) {
+	prefix, ok := hashKey(idxAddrPrefix, scriptHash)
+	if !ok {
+		return nil, fmt.Errorf("invalid scripthash %q", scriptHash)
+	}
+
+	history := make([]indexedHistTx, 0)
+	iter := idx.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		rest := iter.Key()[len(prefix):]
+		var delta Amount
+		if err := json.Unmarshal(iter.Value(), &delta); err != nil {
+			return nil, fmt.Errorf("corrupt index entry %x: %v", iter.Key(), err)
+		}
+		history = append(history, indexedHistTx{
+			HistoryTransaction: HistoryTransaction{
+				Height: int(binary.BigEndian.Uint32(rest[:4])),
+				TxHash: hex.EncodeToString(rest[4:]),
+			},
+			Delta: delta,
+		})
+	}
+	return history, iter.Error()
+}
+
+// spends returns the indexed spends of the outputs of a transaction, by
+// output index.
+func (idx *chainIndex) spends(txid string) map[int]indexedSpend {
+	spends := make(map[int]indexedSpend)
+	prefix, ok := hashKey(idxSpendPrefix, txid)
+	if idx == nil || !ok {
+		return spends
+	}
+
+	iter := idx.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var spend indexedSpend
+		if err := json.Unmarshal(iter.Value(), &spend); err != nil {
+			fmt.Println("Error: corrupt index entry:", err)
+			continue
+		}
+		spends[int(binary.BigEndian.Uint32(iter.Key()[len(prefix):]))] = spend
+	}
+	if err := iter.Error(); err != nil {
+		fmt.Println("Error:", err)
+	}
+	return spends
+}
+
+// fullTx returns the transaction as the tx endpoint shows it.
+func (rec indexedTx) fullTx(cc *chainConfig) FullTransaction {
+	return FullTransaction{
+		TxID:   rec.Tx.TxID,
+		Hex:    rec.Tx.Hex,
+		Height: rec.Height,
+		Size:   rec.Tx.Size,
+		VSize:  rec.Tx.Vsize,
+		Vin:    rec.Prevouts,
+		Vout:   fullVouts(rec.Tx, cc),
+	}
+}
+
+// addBlock indexes the block on top of the current tip, resolving the inputs
+// of its transactions from the outputs already in the index.
+func (idx *chainIndex) addBlock(block BlockData) error {
+	tip := idx.currentTip()
+	height := int(block.Height)
+	if height != tip.Height+1 || (tip.Height >= 0 && block.PreviousBlockHash != tip.Hash) {
+		return fmt.Errorf("%w: block %s at height %d, index tip %s at height %d",
+			errIndexFork, block.Hash, height, tip.Hash, tip.Height)
+	}
+
+	batch := new(leveldb.Batch)
+
+	// Outputs created in this block, for transactions spending them later in
+	// the same block
+	outs := make(map[string]indexedOutput)
+	// Names whose state the block changed
+	names := make(map[string]indexedName)
+
+	txids := make([]string, 0, len(block.Tx))
+	for position, tx := range block.Tx {
+		rec := indexedTx{Tx: electrumTxFromCore(tx, block), Height: height}
+		// Balance change of every script the transaction touches
+		scripts := make(map[string]Amount)
+
+		for _, vout := range tx.Vout {
+			out := indexedOutput{
+				Value:   toSats(vout.Value),
+				Address: vout.ScriptPubKey.Address,
+				Script:  vout.ScriptPubKey.Hex,
+			}
+			key := outKey(tx.TxID, int(vout.N))
+			outs[string(key)] = out
+			if err := putJSON(batch, key, out); err != nil {
+				return err
+			}
+			scripts[out.Script] += out.Value
+		}
+
+		for n, vin := range tx.Vin {
+			// Block Rewards won't have a TxId
+			if vin.TxID == "" {
+				continue
+			}
+			spend := indexedSpend{TxID: tx.TxID, Vin: n, Height: height}
+			if err := putJSON(batch, spendKey(vin.TxID, int(vin.Vout)), spend); err != nil {
+				return err
+			}
+			key := outKey(vin.TxID, int(vin.Vout))
+			out, ok := outs[string(key)]
+			if !ok {
+				found, err := idx.get(key, &out)
+				if err != nil {
+					return err
+				}
+				if !found {
+					return fmt.Errorf("output %s:%d spent by %s is not in the index", vin.TxID, int(vin.Vout), tx.TxID)
+				}
+			}
+			rec.Prevouts = append(rec.Prevouts, FullVin{TxID: vin.TxID, Amount: out.Value, Index: int(vin.Vout), Address: out.Address})
+			scripts[out.Script] -= out.Value
+		}
+
+		if idx.cc.hasNames() {
+			if err := idx.indexNameOps(batch, tx, position, height, names); err != nil {
+				return err
+			}
+		}
+
+		txKey, _ := hashKey(idxTxPrefix, tx.TxID)
+		if err := putJSON(batch, txKey, rec); err != nil {
+			return err
+		}
+		// A name output has the scripthash of its owner's plain outputs, so
+		// their changes add up under the same key
+		deltas := make(map[string]Amount, len(scripts))
+		for script, delta := range scripts {
+			key, err := idx.addrKey(script, height, tx.TxID)
+			if err != nil {
+				return fmt.Errorf("tx %s: %v", tx.TxID, err)
+			}
+			deltas[string(key)] += delta
+		}
+		for key, delta := range deltas {
+			if err := putJSON(batch, []byte(key), delta); err != nil {
+				return err
+			}
+		}
+		txids = append(txids, tx.TxID)
+	}
+
+	header := block
+	header.Tx = nil
+	blockKey, ok := hashKey(idxBlockPrefix, block.Hash)
+	if !ok {
+		return fmt.Errorf("invalid block hash %q", block.Hash)
+	}
+	if err := putJSON(batch, blockKey, indexedBlock{Block: header, TxIDs: txids}); err != nil {
+		return err
+	}
+	hash, _ := hex.DecodeString(block.Hash)
+	batch.Put(heightKey(height), hash)
+
+	newTip := indexTip{Height: height, Hash: block.Hash}
+	if err := putJSON(batch, idxTipKey, newTip); err != nil {
+		return err
+	}
+
+	if err := idx.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	idx.tipMu.Lock()
+	idx.tip = newTip
+	idx.tipMu.Unlock()
+	return nil
+}
+
+// removeTip takes the tip block out of the index, along with everything
+// that was indexed for it.
+func (idx *chainIndex) removeTip() error {
+	tip := idx.currentTip()
+	if tip.Height < 0 {
+		return nil
+	}
+
+	blockKey, _ := hashKey(idxBlockPrefix, tip.Hash)
+	var block indexedBlock
+	found, err := idx.get(blockKey, &block)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("index tip %s is missing", tip.Hash)
+	}
+
+	// All reads go to the database, so outputs created and spent within the
+	// block are still there while the batch is built.
+	batch := new(leveldb.Batch)
+	names := make(map[string]struct{})
+	for position, txid := range block.TxIDs {
+		txKey, _ := hashKey(idxTxPrefix, txid)
+		var rec indexedTx
+		found, err := idx.get(txKey, &rec)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("indexed tx %s of block %s is missing", txid, tip.Hash)
+		}
+
+		scripts := make(map[string]struct{})
+		for _, vout := range rec.Tx.Vout {
+			batch.Delete(outKey(txid, vout.N))
+			scripts[vout.ScriptPubKey.Hex] = struct{}{}
+		}
+		for _, prevout := range rec.Prevouts {
+			var out indexedOutput
+			if _, err := idx.get(outKey(prevout.TxID, prevout.Index), &out); err != nil {
+				return err
+			}
+			scripts[out.Script] = struct{}{}
+			batch.Delete(spendKey(prevout.TxID, prevout.Index))
+		}
+		keys := make(map[string]struct{}, len(scripts))
+		for script := range scripts {
+			if key, err := idx.addrKey(script, tip.Height, txid); err == nil {
+				keys[string(key)] = struct{}{}
+			}
+		}
+		for key := range keys {
+			batch.Delete([]byte(key))
+		}
+		if idx.cc.hasNames() {
+			idx.unindexNameOps(batch, rec.Tx, position, tip.Height, names)
+		}
+		batch.Delete(txKey)
+	}
+	if err := idx.restoreNames(batch, names, tip.Height); err != nil {
+		return err
+	}
+	batch.Delete(blockKey)
+	batch.Delete(heightKey(tip.Height))
+
+	newTip := indexTip{Height: tip.Height - 1, Hash: block.Block.PreviousBlockHash}
+	if newTip.Height < 0 {
+		batch.Delete(idxTipKey)
+	} else if err := putJSON(batch, idxTipKey, newTip); err != nil {
+		return err
+	}
+
+	if err := idx.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	idx.tipMu.Lock()
+	idx.tip = newTip
+	idx.tipMu.Unlock()
+	return nil
+}
+
+// electrumTxFromCore converts a transaction from a verbose getblock into the
+// form Electrum servers return, which is how the handlers use transactions.
+func electrumTxFromCore(tx TxData, block BlockData) ElectrumTransaction {
+	etx := ElectrumTransaction{
+		TxID:      tx.TxID,
+		Hash:      tx.Hash,
+		Version:   int(tx.Version),
+		Size:      int(tx.Size),
+		Vsize:     int(tx.Vsize),
+		Weight:    int(tx.Weight),
+		Locktime:  int(tx.Locktime),
+		Hex:       tx.Hex,
+		BlockHash: block.Hash,
+		Time:      int64(block.Time),
+		BlockTime: int64(block.Time),
+	}
+	for _, vin := range tx.Vin {
+		etx.Vin = append(etx.Vin, ElectrumVinData{
+			TxID:      vin.TxID,
+			Vout:      int(vin.Vout),
+			ScriptSig: ElectrumScriptSigData{Asm: vin.ScriptSig.Asm, Hex: vin.ScriptSig.Hex},
+			Sequence:  int(vin.Sequence),
+		})
+	}
+	for _, vout := range tx.Vout {
+		etx.Vout = append(etx.Vout, ElectrumVoutData{
+			Value: vout.Value,
+			N:     int(vout.N),
+			ScriptPubKey: ElectrumScriptPubKeyData{
+				Asm:     vout.ScriptPubKey.Asm,
+				Hex:     vout.ScriptPubKey.Hex,
+				Address: vout.ScriptPubKey.Address,
+				Type:    vout.ScriptPubKey.Type,
+			},
+		})
+	}
+	return etx
+}
+
+func putJSON(batch *leveldb.Batch, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	batch.Put(key, data)
+	return nil
+}
+
+// hashKey returns the key for a hex hash under prefix.
+func hashKey(prefix byte, hash string) ([]byte, bool) {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != 32 {
+		return nil, false
+	}
+	return append([]byte{prefix}, b...), true
+}
+
+func heightKey(height int) []byte {
+	key := []byte{idxHeightPrefix, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(key[1:], uint32(height))
+	return key
+}
+
+func outKey(txid string, vout int) []byte {
+	key, _ := hashKey(idxOutPrefix, txid)
+	return binary.BigEndian.AppendUint32(key, uint32(vout))
+}
+
+func spendKey(txid string, vout int) []byte {
+	key, _ := hashKey(idxSpendPrefix, txid)
+	return binary.BigEndian.AppendUint32(key, uint32(vout))
+}
+
+// addrKey returns the history key for an output script, given in hex, keyed
+// by its Electrum scripthash like ElectrumScripthash computes it.
+func (idx *chainIndex) addrKey(script string, height int, txid string) ([]byte, error) {
+	b, err := hex.DecodeString(script)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script %q", script)
+	}
+	txidBytes, err := hex.DecodeString(txid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid txid %q", txid)
+	}
+	key := append([]byte{idxAddrPrefix}, scriptHash(addressScript(b, idx.cc))...)
+	key = binary.BigEndian.AppendUint32(key, uint32(height))
+	return append(key, txidBytes...), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// TestIndexNameAndCoinOutputsToOneAddress checks that a transaction paying
+// an address through both a name output and a plain output gets a single
+// history entry with the sum of their balance changes.
+func TestIndexNameAndCoinOutputsToOneAddress(t *testing.T) {
+	cc := &chainConfig{name: "nmc", params: &nmcRegTestParams}
+	idx, err := openIndex(t.TempDir(), cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.db.Close() })
+
+	owner := testOwnerScript(t)
+	ownerHex := hex.EncodeToString(owner)
+	nameScript := hex.EncodeToString(buildScript(t, txscript.OP_2, []byte("d/example"), []byte{0x01, 0x02, 0x03},
+		[]byte("{}"), txscript.OP_2DROP, txscript.OP_2DROP, rawBytes(owner)))
+	hash := func(n int) string { return fmt.Sprintf("%064x", n) }
+
+	funding := TxData{
+		TxID: hash(100),
+		Vout: []VoutData{{Value: 1, N: 0, ScriptPubKey: ScriptPubKeyData{Hex: ownerHex}}},
+	}
+	// Registers a name and sends the change back to the same address
+	register := TxData{
+		TxID: hash(101),
+		Vin:  []VinData{{TxID: funding.TxID, Vout: 0}},
+		Vout: []VoutData{
+			{Value: 0.01, N: 0, ScriptPubKey: ScriptPubKeyData{Hex: nameScript}},
+			{Value: 0.5, N: 1, ScriptPubKey: ScriptPubKeyData{Hex: ownerHex}},
+		},
+	}
+	blocks := []BlockData{
+		{Hash: hash(1), Height: 0, Tx: []TxData{funding}},
+		{Hash: hash(2), Height: 1, PreviousBlockHash: hash(1), Tx: []TxData{register}},
+	}
+	for _, block := range blocks {
+		if err := idx.addBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scriptHash := hex.EncodeToString(scriptHash(owner))
+	history, err := idx.history(scriptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Amount{funding.TxID: 100000000, register.TxID: -49000000}
+	if len(history) != len(want) {
+		t.Fatalf("got %d history entries, want %d: %+v", len(history), len(want), history)
+	}
+	for _, h := range history {
+		if h.Delta != want[h.TxHash] {
+			t.Errorf("tx %s: got delta %d, want %d", h.TxHash, h.Delta, want[h.TxHash])
+		}
+	}
+
+	if err := idx.removeTip(); err != nil {
+		t.Fatal(err)
+	}
+	history, err = idx.history(scriptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].TxHash != funding.TxID {
+		t.Errorf("after removing the tip got history %+v", history)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// indexBatchBlocks is how many blocks are fetched from Core at once while
+	// catching up.
+	indexBatchBlocks = 10
+
+	// indexLogInterval is how often, in blocks, progress is logged while
+	// catching up.
+	indexLogInterval = 1000
+
+	// indexRetryInterval is how long the indexer waits after an error.
+	indexRetryInterval = 10 * time.Second
+)
+
+// errIndexFork is returned by addBlock for a block that does not build on the
+// index tip.
+var errIndexFork = errors.New("block does not extend the index tip")
+
+// run keeps the index in step with the node. It syncs whenever wake fires,
+// which the tip follower does for every new tip.
+func (idx *chainIndex) run() {
+	for {
+		wait := time.Duration(0)
+		if err := idx.sync(context.Background()); err != nil {
+			fmt.Println("Error indexing", idx.cc.name+":", err)
+			wait = indexRetryInterval
+		}
+
+		if wait > 0 {
+			select {
+			case <-idx.wake:
+			case <-time.After(wait):
+			}
+		} else {
+			<-idx.wake
+		}
+	}
+}
+
+// wakeUp makes the indexer sync.
+func (idx *chainIndex) wakeUp() {
+	select {
+	case idx.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sync indexes blocks from the node, from genesis on the first run, until the
+// index has caught up with it. If the index tip has been reorganised out of
+// the node's chain, the index is rolled back to the fork point first.
+func (idx *chainIndex) sync(ctx context.Context) error {
+	for {
+		height, err := idx.cc.rpc.getBlockCount(ctx)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(&idx.chainHeight, int64(height))
+
+		tip := idx.currentTip()
+		if tip.Height >= 0 {
+			onChain := false
+			if tip.Height <= height {
+				hash, err := idx.cc.rpc.getBlockHash(ctx, tip.Height)
+				if err != nil {
+					return err
+				}
+				onChain = hash == tip.Hash
+			}
+			if !onChain {
+				if err := idx.rollBack(ctx, height); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if tip.Height >= height {
+			return nil
+		}
+
+		var heights []int
+		for h := tip.Height + 1; h <= height && len(heights) < indexBatchBlocks; h++ {
+			heights = append(heights, h)
+		}
+		hashes, err := idx.cc.rpc.getBlockHashes(ctx, heights)
+		if err != nil {
+			return err
+		}
+		blocks, err := idx.cc.rpc.getBlocks(ctx, hashes)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			err := idx.addBlock(block)
+			if errors.Is(err, errIndexFork) {
+				// The chain changed while fetching; start over, which
+				// finds the fork.
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if h := int(block.Height); h%indexLogInterval == 0 || h == height {
+				fmt.Printf("Indexed %s block %d of %d\n", idx.cc.name, h, height)
+			}
+		}
+	}
+}
+
+// rollBack removes blocks from the index until its tip is back in the node's
+// chain, whose height is given.
+func (idx *chainIndex) rollBack(ctx context.Context, height int) error {
+	oldTip := idx.currentTip()
+
+	fork := oldTip.Height
+	if fork > height {
+		fork = height
+	}
+	for ; fork >= 0; fork-- {
+		hash, err := idx.cc.rpc.getBlockHash(ctx, fork)
+		if err != nil {
+			return err
+		}
+		if indexed, ok := idx.blockHash(fork); ok && indexed == hash {
+			break
+		}
+	}
+
+	for idx.currentTip().Height > fork {
+		if err := idx.removeTip(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Rolled back %s index from %d %s to %d\n", idx.cc.name, oldTip.Height, oldTip.Hash, fork)
+	return nil
+}
@@ -1,41 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/gorilla/mux"
 )
 
-const (
-	rpcUser     = "rpc"
-	rpcPass     = "rpc"
-	electrumURL = "127.0.0.1:50001"
-	coreURL     = "http://localhost"
-	walletURL   = "/wallet/bank" // bank wallet for regtest use
-	nmcPort     = 18443
-	btcPort     = 18444
-)
-
-var (
-	nmcParams chaincfg.Params = nmcMainnetChainParams
-	btcParams chaincfg.Params = chaincfg.MainNetParams
-)
-
 // postHandler is a dedicated function to handle POST requests to "/post".
 func templateEndpoint(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Error reading request body")
 		return
 	}
 
@@ -47,7 +33,7 @@ func templateEndpoint(w http.ResponseWriter, r *http.Request) {
 	// Unmarshal the JSON data
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Error unmarshaling JSON data", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
 		return
 	}
 
@@ -69,7 +55,7 @@ func templateEndpoint(w http.ResponseWriter, r *http.Request) {
 	// // Marshal the struct into JSON
 	resJSON, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
 		return
 	}
 
@@ -80,25 +66,111 @@ func templateEndpoint(w http.ResponseWriter, r *http.Request) {
 }
 
 func exampleElectrum() {
-	scriptHash, _ := ElectrumScripthash("mqC6EWespCSjGPXZtz8VCxRSNtrep7FJDA", &nmcParams)
+	scriptHash, _ := ElectrumScripthash("mqC6EWespCSjGPXZtz8VCxRSNtrep7FJDA", cfg.NMC.params)
 	params := []any{scriptHash}
-	reqJSON := createElectrumRequest("blockchain.scripthash.get_balance", params)
-	fmt.Println(reqJSON)
-	fmt.Println()
-	sendElectrumRequest(reqJSON)
+	var balance AddrBal
+	err := cfg.NMC.electrum.call(context.Background(), "blockchain.scripthash.get_balance", params, &balance)
+	fmt.Println(balance, err)
+}
+
+// registerChainRoutes adds the /<chain>/... endpoints for a single chain. Every
+// handler is bound to that chain's backends.
+func registerChainRoutes(router *mux.Router, cc *chainConfig) {
+	sub := router.PathPrefix("/" + cc.name).Subrouter()
+
+	// The original endpoints take POST with a JSON body
+	sub.HandleFunc("/loadhomepage", loadHomeReq(cc))
+	sub.HandleFunc("/address", addressReq(cc))
+	sub.HandleFunc("/block", blockReq(cc))
+	sub.HandleFunc("/tx", txReq(cc))
+	sub.HandleFunc("/outspends", outspendsReq(cc))
+	sub.HandleFunc("/utxos", utxosReq(cc))
+	sub.HandleFunc("/search", searchReq(cc)).Methods(http.MethodPost)
+
+	// The same as GET, with options as query parameters
+	get := sub.Methods(http.MethodGet).Subrouter()
+	get.HandleFunc("/tx/{txid}", txGetReq(cc))
+	get.HandleFunc("/tx/{txid}/outspends", outspendsGetReq(cc))
+	get.HandleFunc("/block/{id}", blockGetReq(cc))
+	get.HandleFunc("/address/{addr}", addressGetReq(cc))
+	get.HandleFunc("/address/{addr}/utxos", utxosGetReq(cc))
+	get.HandleFunc("/scripthash/{hash}", scriptHashGetReq(cc))
+	get.HandleFunc("/search", searchGetReq(cc))
+	sub.HandleFunc("/cachestats", cacheStatsReq(cc)).Methods(http.MethodGet)
+
+	if cc.hasNames() {
+		sub.HandleFunc("/name", nameReq(cc))
+		sub.HandleFunc("/namehistory", nameHistoryReq(cc))
+		sub.HandleFunc("/namesearch", nameSearchReq(cc))
+		// Names can contain slashes, so the history route has to come first
+		get.HandleFunc("/name/{name:.+}/history", nameHistoryGetReq(cc))
+		get.HandleFunc("/name/{name:.+}", nameGetReq(cc))
+		get.HandleFunc("/names/expiring", expiringNamesReq(cc))
+		get.HandleFunc("/names/expired", expiredNamesReq(cc))
+		get.HandleFunc("/names/search", nameSearchGetReq(cc))
+	}
+	sub.HandleFunc("/reorgs", reorgsReq(cc)).Methods(http.MethodGet)
+}
+
+// startChain sets up the backends of a chain and starts its background work.
+func startChain(cc *chainConfig) error {
+	cc.rpc = newRPCClient(cc)
+	cc.electrum = newElectrumClient(cc)
+	cc.cache = newLRUCache(cc.CacheSize, cc.CacheUnconfirmedTTL)
+
+	// Anything cached from orphaned blocks is wrong now
+	cc.follower = newTipFollower(cc)
+	cc.follower.handleReorg(func(event ReorgEvent) {
+		for _, hash := range event.Orphaned {
+			cc.cache.dropBlock(hash)
+		}
+	})
+
+	if cc.Index {
+		var err error
+		cc.index, err = openIndex(cc.indexPath(), cc)
+		if err != nil {
+			return err
+		}
+		// The indexer rolls itself back when it finds its tip has left the
+		// chain
+		cc.follower.handleTip(func(BlockRef) {
+			cc.index.wakeUp()
+		})
+		go cc.index.run()
+	}
+
+	go cc.follower.run()
+	return nil
 }
 
 func main() {
+	// Load and validate the configuration
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, cc := range cfg.chains() {
+		if err := startChain(cc); err != nil {
+			fmt.Fprintln(os.Stderr, cc.name+":", err)
+			os.Exit(1)
+		}
+	}
 
-	// Create a new router
+	// Create a new router, answering unknown paths and methods in the same
+	// JSON as every other error
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundReq)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedReq)
 
 	// Endpoints
 	router.HandleFunc("/template", templateEndpoint)
-	router.HandleFunc("/nmc/loadhomepage", nmcLoadHomeReq)
-	router.HandleFunc("/nmc/address", nmcAddressReq)
-	router.HandleFunc("/nmc/block", nmcBlockReq)
-	router.HandleFunc("/nmc/tx", nmcTxReq)
+	for _, cc := range cfg.chains() {
+		registerChainRoutes(router, cc)
+	}
 
 	// Set up a handler function to handle CORS headers
 	corsHandler := func(next http.Handler) http.Handler {
@@ -119,45 +191,77 @@ func main() {
 		})
 	}
 
-	// Use the CORS handler for all routes
-	http.Handle("/", corsHandler(router))
+	// Use the CORS handler for all routes, outside the panic recovery so
+	// that errors still carry the CORS headers
+	http.Handle("/", corsHandler(recoverPanics(router)))
 
-	// Start the server on port 8080
-	http.ListenAndServe(":8080", nil)
+	// Start the server
+	if err := http.ListenAndServe(cfg.Listen, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 // postHandler is a dedicated function to handle POST requests to "/post".
-func nmcTxReq(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func txReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
+
+		// Define a struct to unmarshal the JSON data
+		var req struct {
+			TxId string `json:"txid"`
+		}
+
+		// Unmarshal the JSON data
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		serveTx(w, r, req.TxId, cc)
 	}
+}
 
-	// Read the request body
-	body, err := io.ReadAll(r.Body)
+// txGetReq handles GET /tx/{txid}.
+func txGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveTx(w, r, mux.Vars(r)["txid"], cc)
+	}
+}
+
+func serveTx(w http.ResponseWriter, r *http.Request, txid string, cc *chainConfig) {
+	unit, err := requestUnit(r)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Define a struct to unmarshal the JSON data
-	var req struct {
-		TxId string `json:"txid"`
+	if !isHash(txid) {
+		writeError(w, http.StatusBadRequest, "Invalid txid")
+		return
 	}
 
-	// Unmarshal the JSON data
-	err = json.Unmarshal(body, &req)
+	tx, err := getFullTx(r.Context(), txid, cc)
 	if err != nil {
-		http.Error(w, "Error unmarshaling JSON data", http.StatusBadRequest)
+		writeBackendError(w, err, "transaction")
 		return
 	}
 
-	tx := getFullTx(req.TxId)
-
 	// // Marshal the struct into JSON
-	resJSON, err := json.Marshal(tx)
+	resJSON, err := marshalWithUnit(tx, unit)
 	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
 		return
 	}
 
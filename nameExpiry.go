@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// nameExpiryWindow is how many blocks ahead, or back for expired names,
+	// the expiry listings look when the request does not say.
+	nameExpiryWindow = 2016
+
+	// namePageSize is how many names a listing returns when the request does
+	// not say, and nameMaxPageSize the most it returns at once.
+	namePageSize    = 100
+	nameMaxPageSize = 1000
+)
+
+// NameExpiryPage is one page of the names expiring, or that have expired,
+// within Window blocks of the tip at height Tip. Total is the number of names
+// in the window, over all pages.
+type NameExpiryPage struct {
+	Tip    int         `json:"tip"`
+	Window int         `json:"window"`
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Names  []NameState `json:"names"`
+}
+
+// nameExpiryQuery selects the names of an expiry listing.
+type nameExpiryQuery struct {
+	Window int
+	Offset int
+	Limit  int
+}
+
+// getExpiringNames returns the names that will expire within the next
+// q.Window blocks, or with expired set those that expired within the last
+// q.Window blocks, in the order they expire. It needs the name index.
+func getExpiringNames(expired bool, q nameExpiryQuery, cc *chainConfig) (NameExpiryPage, error) {
+	tip := cc.index.currentTip().Height
+	page := NameExpiryPage{Tip: tip, Window: q.Window, Offset: q.Offset, Limit: q.Limit}
+
+	// Names count as expired from their expiry height on
+	first, last := tip+1, tip+q.Window
+	if expired {
+		first, last = tip-q.Window+1, tip
+	}
+
+	names, total, err := cc.index.expiringNames(first, last, q.Offset, q.Limit)
+	if err != nil {
+		return page, err
+	}
+	page.Total = total
+	page.Names = make([]NameState, 0, len(names))
+	for _, name := range names {
+		page.Names = append(page.Names, newIndexedNameState(name, tip, cc.nameRules()))
+	}
+	return page, nil
+}
+
+// expiringNamesReq handles GET /names/expiring, listing the names that will
+// expire within ?window= blocks.
+func expiringNamesReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveNameExpiry(w, r, false, cc)
+	}
+}
+
+// expiredNamesReq handles GET /names/expired, listing the names that expired
+// within the last ?window= blocks.
+func expiredNamesReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveNameExpiry(w, r, true, cc)
+	}
+}
+
+func serveNameExpiry(w http.ResponseWriter, r *http.Request, expired bool, cc *chainConfig) {
+	var q nameExpiryQuery
+	values := r.URL.Query()
+	for name, v := range map[string]*int{"window": &q.Window, "offset": &q.Offset, "limit": &q.Limit} {
+		if s := values.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid query: invalid %s %q", name, s))
+				return
+			}
+			*v = n
+		}
+	}
+	// No name lasts longer than the network's expiration depth
+	maxWindow := cc.nameRules().maxExpirationDepth
+	if q.Window < 0 || q.Window > maxWindow || q.Offset < 0 || q.Limit < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid query")
+		return
+	}
+	if q.Window == 0 {
+		q.Window = nameExpiryWindow
+		if q.Window > maxWindow {
+			q.Window = maxWindow
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = namePageSize
+	}
+	if q.Limit > nameMaxPageSize {
+		q.Limit = nameMaxPageSize
+	}
+
+	// Only the index knows which names expire when
+	if !cc.index.synced() {
+		writeError(w, http.StatusServiceUnavailable, "The name index is not built yet")
+		return
+	}
+
+	page, err := getExpiringNames(expired, q, cc)
+	if err != nil {
+		writeBackendError(w, err, "names")
+		return
+	}
+
+	resJSON, err := json.Marshal(page)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// indexedNameOp is a name operation in a confirmed transaction. Position is
+// the index of the transaction in its block, which orders operations on the
+// same name within a block.
+type indexedNameOp struct {
+	Op       string `json:"op"`
+	Name     []byte `json:"name"`
+	Value    []byte `json:"value"`
+	TxID     string `json:"txid"`
+	Vout     int    `json:"vout"`
+	Address  string `json:"address"`
+	Height   int    `json:"height"`
+	Position int    `json:"position"`
+}
+
+// indexedName is the current state of a name: its last operation, and the
+// height of the NAME_FIRSTUPDATE that registered it.
+type indexedName struct {
+	indexedNameOp
+	Registered int `json:"registered"`
+}
+
+// expiresAt returns the height at which the name expires under rules, unless
+// it is updated before. At that height it counts as expired, as in Namecoin
+// Core.
+func (state indexedName) expiresAt(rules *nameRules) int {
+	return rules.expiresAt(state.Height)
+}
+
+// indexNameOps adds the NAME_FIRSTUPDATE and NAME_UPDATE outputs of a block's
+// transaction to batch. names holds the states already changed by the block,
+// which are not in the database yet. NAME_NEW only commits to a name without
+// revealing it, so it is not indexed.
+func (idx *chainIndex) indexNameOps(batch *leveldb.Batch, tx TxData, position, height int, names map[string]indexedName) error {
+	for _, vout := range tx.Vout {
+		script, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+		if err != nil {
+			continue
+		}
+		ns, ok := decodeNameScript(script)
+		if !ok || ns.op == nameOpNew {
+			continue
+		}
+
+		op := indexedNameOp{
+			Op:       ns.op,
+			Name:     ns.name,
+			Value:    ns.value,
+			TxID:     tx.TxID,
+			Vout:     int(vout.N),
+			Address:  vout.ScriptPubKey.Address,
+			Height:   height,
+			Position: position,
+		}
+		if op.Address == "" {
+			op.Address = ns.address(idx.cc.params)
+		}
+		if err := putJSON(batch, nameHistKey(ns.name, height, position, op.Vout), op); err != nil {
+			return err
+		}
+
+		prev, hadPrev := names[string(ns.name)]
+		if !hadPrev {
+			var err error
+			if hadPrev, err = idx.get(nameKey(ns.name), &prev); err != nil {
+				return err
+			}
+		}
+		state := indexedName{indexedNameOp: op, Registered: height}
+		if ns.op == nameOpUpdate {
+			state.Registered = prev.Registered
+		}
+		names[string(ns.name)] = state
+
+		if hadPrev {
+			batch.Delete(nameExpiryKey(prev.expiresAt(idx.cc.nameRules()), ns.name))
+		}
+		if err := idx.putNameState(batch, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putNameState adds the current state of a name to batch, along with its
+// entry in the expiry order.
+func (idx *chainIndex) putNameState(batch *leveldb.Batch, state indexedName) error {
+	batch.Put(nameExpiryKey(state.expiresAt(idx.cc.nameRules()), state.Name), nil)
+	return putJSON(batch, nameKey(state.Name), state)
+}
+
+// unindexNameOps adds to batch the removal of the name operations of the
+// tip block's transaction, which is at position in the block.
+func (idx *chainIndex) unindexNameOps(batch *leveldb.Batch, tx ElectrumTransaction, position, height int, names map[string]struct{}) {
+	for _, vout := range tx.Vout {
+		script, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+		if err != nil {
+			continue
+		}
+		ns, ok := decodeNameScript(script)
+		if !ok || ns.op == nameOpNew {
+			continue
+		}
+		batch.Delete(nameHistKey(ns.name, height, position, vout.N))
+		names[string(ns.name)] = struct{}{}
+	}
+}
+
+// restoreNames adds to batch the states the given names had before the block
+// at height, which is being removed.
+func (idx *chainIndex) restoreNames(batch *leveldb.Batch, names map[string]struct{}, height int) error {
+	for name := range names {
+		var current indexedName
+		found, err := idx.get(nameKey([]byte(name)), &current)
+		if err != nil {
+			return err
+		}
+		if found {
+			batch.Delete(nameExpiryKey(current.expiresAt(idx.cc.nameRules()), current.Name))
+		}
+
+		history, err := idx.nameOps([]byte(name))
+		if err != nil {
+			return err
+		}
+		var state *indexedName
+		for _, op := range history {
+			if op.Height >= height {
+				break
+			}
+			registered := op.Height
+			if op.Op == nameOpUpdate && state != nil {
+				registered = state.Registered
+			}
+			state = &indexedName{indexedNameOp: op, Registered: registered}
+		}
+		if state == nil {
+			batch.Delete(nameKey([]byte(name)))
+		} else if err := idx.putNameState(batch, *state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// name returns the current state of a name, if the index has one.
+func (idx *chainIndex) name(name []byte) (indexedName, bool) {
+	var state indexedName
+	if !idx.lookup(nameKey(name), &state) {
+		return indexedName{}, false
+	}
+	return state, true
+}
+
+// nameOps returns the indexed operations on a name, oldest first.
+func (idx *chainIndex) nameOps(name []byte) ([]indexedNameOp, error) {
+	ops := make([]indexedNameOp, 0)
+	iter := idx.db.NewIterator(util.BytesPrefix(nameHistPrefix(name)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var op indexedNameOp
+		if err := json.Unmarshal(iter.Value(), &op); err != nil {
+			return nil, fmt.Errorf("corrupt index entry %x: %v", iter.Key(), err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, iter.Error()
+}
+
+// expiringNames returns the names expiring at heights from first to last,
+// soonest first, skipping the first offset of them and returning at most
+// limit. It also returns how many there are in all.
+func (idx *chainIndex) expiringNames(first, last, offset, limit int) ([]indexedName, int, error) {
+	if first < 0 {
+		first = 0
+	}
+	if last < first {
+		return []indexedName{}, 0, nil
+	}
+	names := make([]indexedName, 0)
+	total := 0
+	iter := idx.db.NewIterator(&util.Range{
+		Start: nameExpiryKey(first, nil),
+		Limit: nameExpiryKey(last+1, nil),
+	}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		total++
+		if total <= offset || len(names) >= limit {
+			continue
+		}
+		name := iter.Key()[5:]
+		var state indexedName
+		found, err := idx.get(nameKey(name), &state)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("indexed name %x is missing", name)
+		}
+		names = append(names, state)
+	}
+	return names, total, iter.Error()
+}
+
+// searchNames returns up to limit names starting with prefix that match,
+// in byte order, starting after the name after if that is set. It also
+// reports whether there are more.
+func (idx *chainIndex) searchNames(prefix, after []byte, match func(indexedName) bool, limit int) ([]indexedName, bool, error) {
+	names := make([]indexedName, 0)
+	r := util.BytesPrefix(nameKey(prefix))
+	if after != nil {
+		// The smallest key after the name's own
+		if start := append(nameKey(after), 0); bytes.Compare(start, r.Start) > 0 {
+			r.Start = start
+		}
+	}
+
+	iter := idx.db.NewIterator(r, nil)
+	defer iter.Release()
+	for iter.Next() {
+		var state indexedName
+		if err := json.Unmarshal(iter.Value(), &state); err != nil {
+			return nil, false, fmt.Errorf("corrupt index entry %x: %v", iter.Key(), err)
+		}
+		if !match(state) {
+			continue
+		}
+		if len(names) == limit {
+			return names, true, nil
+		}
+		names = append(names, state)
+	}
+	return names, false, iter.Error()
+}
+
+func nameKey(name []byte) []byte {
+	return append([]byte{idxNamePrefix}, name...)
+}
+
+// nameHistPrefix starts the history keys of a name. Names can hold any bytes,
+// so they are hashed to keep one name's keys from running into another's.
+func nameHistPrefix(name []byte) []byte {
+	sum := sha256.Sum256(name)
+	return append([]byte{idxNameHistPrefix}, sum[:]...)
+}
+
+func nameExpiryKey(expiry int, name []byte) []byte {
+	key := binary.BigEndian.AppendUint32([]byte{idxNameExpiryPrefix}, uint32(expiry))
+	return append(key, name...)
+}
+
+func nameHistKey(name []byte, height, position, vout int) []byte {
+	key := nameHistPrefix(name)
+	key = binary.BigEndian.AppendUint32(key, uint32(height))
+	key = binary.BigEndian.AppendUint32(key, uint32(position))
+	return binary.BigEndian.AppendUint32(key, uint32(vout))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NameState is the current state of a name: its value, the address owning
+// it, and the output of the operation that last changed it. Registered is the
+// height of its registration, which only the index knows. The name expires
+// at height ExpiresAt, ExpiresIn blocks from the tip, unless it is updated
+// before; once expired ExpiresIn is 0 or less. Parsed is the value of a d/
+// or id/ name, parsed and resolved, which only the name endpoints fill in.
+type NameState struct {
+	Name          string       `json:"name"`
+	NameEncoding  string       `json:"name_encoding"`
+	Value         string       `json:"value"`
+	ValueEncoding string       `json:"value_encoding"`
+	Address       string       `json:"address"`
+	TxID          string       `json:"txid"`
+	Vout          int          `json:"vout"`
+	Height        int          `json:"height"`
+	ExpiresAt     int          `json:"expires_at"`
+	ExpiresIn     int          `json:"expires_in"`
+	Expired       bool         `json:"expired"`
+	Registered    int          `json:"registered,omitempty"`
+	Parsed        *ParsedValue `json:"parsed,omitempty"`
+}
+
+// NameUpdate is an operation in the history of a name. Op is missing when
+// the history comes from the node, which does not say.
+type NameUpdate struct {
+	Op            string `json:"op,omitempty"`
+	Value         string `json:"value"`
+	ValueEncoding string `json:"value_encoding"`
+	Address       string `json:"address"`
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Height        int    `json:"height"`
+}
+
+// NameHistory lists the operations on a name, oldest first.
+type NameHistory struct {
+	Name         string       `json:"name"`
+	NameEncoding string       `json:"name_encoding"`
+	History      []NameUpdate `json:"history"`
+}
+
+// parseName decodes a name given in encoding, which is UTF-8 if empty.
+func parseName(name string, encoding string) ([]byte, error) {
+	var b []byte
+	switch encoding {
+	case "", nameEncodingUTF8:
+		b = []byte(name)
+	case nameEncodingHex:
+		var err error
+		if b, err = hex.DecodeString(name); err != nil {
+			return nil, fmt.Errorf("invalid hex name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("missing name")
+	}
+	return b, nil
+}
+
+// getName returns the current state of a name. It comes from the index once
+// that has caught up, and from the node otherwise.
+func getName(ctx context.Context, name []byte, cc *chainConfig) (NameState, error) {
+	if cc.index.synced() {
+		state, ok := cc.index.name(name)
+		if !ok {
+			return NameState{}, errNotFound
+		}
+		return newIndexedNameState(state, cc.index.currentTip().Height, cc.nameRules()), nil
+	}
+
+	info, err := cc.rpc.nameShow(ctx, name)
+	if isRPCError(err, rpcErrWallet) {
+		return NameState{}, errNotFound
+	}
+	if err != nil {
+		return NameState{}, err
+	}
+	// The node's tip may differ from the index's, so it says when the name
+	// expires itself
+	state := newNameState(nameOpFromCore(name, info), 0, cc.nameRules())
+	state.ExpiresIn = info.ExpiresIn
+	state.Expired = info.Expired
+	return state, nil
+}
+
+// getNameHistory returns the operations on a name, from the index once that
+// has caught up and from the node otherwise.
+func getNameHistory(ctx context.Context, name []byte, cc *chainConfig) (NameHistory, error) {
+	var ops []indexedNameOp
+	if cc.index.synced() {
+		var err error
+		if ops, err = cc.index.nameOps(name); err != nil {
+			return NameHistory{}, err
+		}
+	} else {
+		infos, err := cc.rpc.nameHistory(ctx, name)
+		if err != nil && !isRPCError(err, rpcErrWallet) {
+			return NameHistory{}, err
+		}
+		for _, info := range infos {
+			ops = append(ops, nameOpFromCore(name, info))
+		}
+	}
+	if len(ops) == 0 {
+		return NameHistory{}, errNotFound
+	}
+
+	res := NameHistory{History: make([]NameUpdate, 0, len(ops))}
+	res.Name, res.NameEncoding = encodeNameData(name)
+	for _, op := range ops {
+		update := NameUpdate{
+			Op:      op.Op,
+			Address: op.Address,
+			TxID:    op.TxID,
+			Vout:    op.Vout,
+			Height:  op.Height,
+		}
+		update.Value, update.ValueEncoding = encodeNameData(op.Value)
+		res.History = append(res.History, update)
+	}
+	return res, nil
+}
+
+// nameOpFromCore converts a name_show or name_history entry into the form the
+// index stores operations in.
+func nameOpFromCore(name []byte, info NameInfo) indexedNameOp {
+	value, _ := hex.DecodeString(info.Value)
+	return indexedNameOp{
+		Name:    name,
+		Value:   value,
+		TxID:    info.TxID,
+		Vout:    info.Vout,
+		Address: info.Address,
+		Height:  info.Height,
+	}
+}
+
+// newIndexedNameState returns the state of an indexed name, with the chain at
+// height tip.
+func newIndexedNameState(name indexedName, tip int, rules *nameRules) NameState {
+	state := newNameState(name.indexedNameOp, tip, rules)
+	state.Registered = name.Registered
+	return state
+}
+
+// newNameState returns the state a name has after op, with the chain at
+// height tip.
+func newNameState(op indexedNameOp, tip int, rules *nameRules) NameState {
+	expiresAt := rules.expiresAt(op.Height)
+	state := NameState{
+		Address:   op.Address,
+		TxID:      op.TxID,
+		Vout:      op.Vout,
+		Height:    op.Height,
+		ExpiresAt: expiresAt,
+		ExpiresIn: expiresAt - tip,
+		Expired:   expiresAt <= tip,
+	}
+	state.Name, state.NameEncoding = encodeNameData(op.Name)
+	state.Value, state.ValueEncoding = encodeNameData(op.Value)
+	return state
+}
+
+// nameRequest is the body of the POST name endpoints. Encoding is how the
+// name is given, utf8 or hex.
+type nameRequest struct {
+	Name     string `json:"name"`
+	Encoding string `json:"encoding"`
+}
+
+func nameReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if req, ok := readNameRequest(w, r); ok {
+			serveName(w, r, req, cc)
+		}
+	}
+}
+
+func nameHistoryReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if req, ok := readNameRequest(w, r); ok {
+			serveNameHistory(w, r, req, cc)
+		}
+	}
+}
+
+// nameGetReq handles GET /name/{name}, with the encoding as a query
+// parameter.
+func nameGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveName(w, r, nameRequest{Name: mux.Vars(r)["name"], Encoding: r.URL.Query().Get("encoding")}, cc)
+	}
+}
+
+// nameHistoryGetReq handles GET /name/{name}/history.
+func nameHistoryGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveNameHistory(w, r, nameRequest{Name: mux.Vars(r)["name"], Encoding: r.URL.Query().Get("encoding")}, cc)
+	}
+}
+
+// readNameRequest reads the body of a POST name request, writing the error
+// response if it can't.
+func readNameRequest(w http.ResponseWriter, r *http.Request) (nameRequest, bool) {
+	var req nameRequest
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return req, false
+	}
+
+	// Read the request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Error reading request body")
+		return req, false
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+		return req, false
+	}
+	return req, true
+}
+
+func serveName(w http.ResponseWriter, r *http.Request, req nameRequest, cc *chainConfig) {
+	name, err := parseName(req.Name, req.Encoding)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid name: "+err.Error())
+		return
+	}
+
+	state, err := getName(r.Context(), name, cc)
+	if err != nil {
+		writeBackendError(w, err, "name")
+		return
+	}
+	state.Parsed = parseNameValue(r.Context(), name, state.valueBytes(), cc)
+	resJSON, err := json.Marshal(state)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
+
+func serveNameHistory(w http.ResponseWriter, r *http.Request, req nameRequest, cc *chainConfig) {
+	name, err := parseName(req.Name, req.Encoding)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid name: "+err.Error())
+		return
+	}
+
+	history, err := getNameHistory(r.Context(), name, cc)
+	if err != nil {
+		writeBackendError(w, err, "name history")
+		return
+	}
+	resJSON, err := json.Marshal(history)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// nameMaxRegexLen bounds the value regex of a name search. Go's regexps run
+// in linear time, but compiling a huge one is still wasted work.
+const nameMaxRegexLen = 256
+
+// NameQuery selects names from the name index, in byte order of the name.
+// Cursor is the nextcursor of the previous page, empty for the first one.
+//
+// Namespace keeps the names in it, such as "d" or "id". Prefix and Contains
+// match the whole name, ValueContains and ValueRegex its current value.
+// Owner is the address holding the name, and MinRegistered and MaxRegistered
+// bound the height the name was registered at.
+type NameQuery struct {
+	Cursor        string `json:"cursor"`
+	Limit         int    `json:"limit"`
+	Namespace     string `json:"namespace"`
+	Prefix        string `json:"prefix"`
+	Contains      string `json:"contains"`
+	ValueContains string `json:"valuecontains"`
+	ValueRegex    string `json:"valueregex"`
+	Owner         string `json:"owner"`
+	MinRegistered int    `json:"minregistered"`
+	MaxRegistered int    `json:"maxregistered"`
+
+	after      []byte
+	keyPrefix  []byte
+	noMatches  bool
+	valueRegex *regexp.Regexp
+}
+
+// NameSearchPage is a page of the names matching a NameQuery.
+type NameSearchPage struct {
+	Names      []NameState `json:"names"`
+	NextCursor string      `json:"nextcursor,omitempty"`
+}
+
+// validate checks q, fills in the default limit and prepares the filters.
+func (q *NameQuery) validate(cc *chainConfig) error {
+	if q.Limit < 0 || q.MinRegistered < 0 || q.MaxRegistered < 0 {
+		return errors.New("negative limit or height")
+	}
+	if q.Cursor != "" {
+		after, err := hex.DecodeString(q.Cursor)
+		if err != nil {
+			return fmt.Errorf("invalid cursor %q", q.Cursor)
+		}
+		q.after = after
+	}
+	if q.ValueRegex != "" {
+		if len(q.ValueRegex) > nameMaxRegexLen {
+			return fmt.Errorf("value regex longer than %d characters", nameMaxRegexLen)
+		}
+		re, err := regexp.Compile(q.ValueRegex)
+		if err != nil {
+			return fmt.Errorf("invalid value regex: %v", err)
+		}
+		q.valueRegex = re
+	}
+	if q.Owner != "" {
+		if _, err := btcutil.DecodeAddress(q.Owner, cc.params); err != nil {
+			return fmt.Errorf("invalid owner address %q", q.Owner)
+		}
+	}
+
+	// Names in the namespace and with the prefix are the ones starting with
+	// the longer of the two, if that starts with the shorter one
+	q.keyPrefix = []byte(q.Prefix)
+	if q.Namespace != "" {
+		namespace := strings.TrimSuffix(q.Namespace, "/") + "/"
+		switch {
+		case strings.HasPrefix(q.Prefix, namespace):
+		case strings.HasPrefix(namespace, q.Prefix):
+			q.keyPrefix = []byte(namespace)
+		default:
+			q.noMatches = true
+		}
+	}
+
+	if q.Limit == 0 {
+		q.Limit = namePageSize
+	}
+	if q.Limit > nameMaxPageSize {
+		q.Limit = nameMaxPageSize
+	}
+	return nil
+}
+
+// matches reports whether a name passes the filters besides its prefix.
+func (q *NameQuery) matches(state indexedName) bool {
+	switch {
+	case q.Contains != "" && !bytes.Contains(state.Name, []byte(q.Contains)):
+		return false
+	case q.ValueContains != "" && !bytes.Contains(state.Value, []byte(q.ValueContains)):
+		return false
+	case q.valueRegex != nil && !q.valueRegex.Match(state.Value):
+		return false
+	case q.Owner != "" && state.Address != q.Owner:
+		return false
+	case q.MinRegistered > 0 && state.Registered < q.MinRegistered:
+		return false
+	case q.MaxRegistered > 0 && state.Registered > q.MaxRegistered:
+		return false
+	}
+	return true
+}
+
+// nameQueryFromURL reads a NameQuery from query parameters named like its
+// JSON fields.
+func nameQueryFromURL(values url.Values) (NameQuery, error) {
+	q := NameQuery{
+		Cursor:        values.Get("cursor"),
+		Namespace:     values.Get("namespace"),
+		Prefix:        values.Get("prefix"),
+		Contains:      values.Get("contains"),
+		ValueContains: values.Get("valuecontains"),
+		ValueRegex:    values.Get("valueregex"),
+		Owner:         values.Get("owner"),
+	}
+	ints := map[string]*int{"limit": &q.Limit, "minregistered": &q.MinRegistered, "maxregistered": &q.MaxRegistered}
+	for name, v := range ints {
+		if s := values.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*v = n
+		}
+	}
+	return q, nil
+}
+
+// searchNames returns the page of names selected by q, which has been
+// validated. It needs the name index.
+func searchNames(q NameQuery, cc *chainConfig) (NameSearchPage, error) {
+	page := NameSearchPage{Names: make([]NameState, 0)}
+	if q.noMatches {
+		return page, nil
+	}
+
+	names, more, err := cc.index.searchNames(q.keyPrefix, q.after, q.matches, q.Limit)
+	if err != nil {
+		return page, err
+	}
+	tip := cc.index.currentTip().Height
+	for _, name := range names {
+		page.Names = append(page.Names, newIndexedNameState(name, tip, cc.nameRules()))
+	}
+	if more {
+		page.NextCursor = hex.EncodeToString(names[len(names)-1].Name)
+	}
+	return page, nil
+}
+
+func nameSearchReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
+
+		var q NameQuery
+		err = json.Unmarshal(body, &q)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		serveNameSearch(w, r, q, cc)
+	}
+}
+
+// nameSearchGetReq handles GET /names/search, with the NameQuery fields as
+// query parameters.
+func nameSearchGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := nameQueryFromURL(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid query: "+err.Error())
+			return
+		}
+		serveNameSearch(w, r, q, cc)
+	}
+}
+
+func serveNameSearch(w http.ResponseWriter, r *http.Request, q NameQuery, cc *chainConfig) {
+	if err := q.validate(cc); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid query: "+err.Error())
+		return
+	}
+
+	// Searching through the node would mean a name_show for every name
+	if !cc.index.synced() {
+		writeError(w, http.StatusServiceUnavailable, "The name index is not built yet")
+		return
+	}
+
+	page, err := searchNames(q, cc)
+	if err != nil {
+		writeBackendError(w, err, "names")
+		return
+	}
+
+	resJSON, err := json.Marshal(page)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
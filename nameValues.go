@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Namespaces with values the explorer understands.
+const (
+	domainNamespace   = "d/"
+	identityNamespace = "id/"
+)
+
+// nameMaxValueLength is the longest value Namecoin allows a name to have.
+// Longer values cannot come from the chain, so they are not parsed.
+const nameMaxValueLength = 1023
+
+// nameMaxImportDepth is how deep import and delegate references are
+// followed, counting the name itself.
+const nameMaxImportDepth = 4
+
+// domainLabel is what a d/ name has to be after the namespace to be a valid
+// .bit domain.
+var domainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ParsedValue is the value of a d/ or id/ name, parsed and with its imports
+// resolved. Imports lists the names it took data from, in the order they
+// were resolved. Valid is false if the value is not JSON, or if any of
+// Errors came up parsing or resolving it.
+type ParsedValue struct {
+	Type     string         `json:"type"`
+	Valid    bool           `json:"valid"`
+	Errors   []string       `json:"errors,omitempty"`
+	Imports  []string       `json:"imports,omitempty"`
+	Domain   *DomainValue   `json:"domain,omitempty"`
+	Records  []DomainRecord `json:"records,omitempty"`
+	Identity *IdentityValue `json:"identity,omitempty"`
+}
+
+// DomainValue is the DNS data of a d/ name. Map holds the subdomains, with
+// "*" for a wildcard. TLS, DS and Info are passed on as they are.
+type DomainValue struct {
+	IP        []string                `json:"ip,omitempty"`
+	IP6       []string                `json:"ip6,omitempty"`
+	NS        []string                `json:"ns,omitempty"`
+	Alias     string                  `json:"alias,omitempty"`
+	Translate string                  `json:"translate,omitempty"`
+	TXT       []string                `json:"txt,omitempty"`
+	Onion     string                  `json:"onion,omitempty"`
+	Email     string                  `json:"email,omitempty"`
+	TLS       json.RawMessage         `json:"tls,omitempty"`
+	DS        json.RawMessage         `json:"ds,omitempty"`
+	Info      json.RawMessage         `json:"info,omitempty"`
+	Map       map[string]*DomainValue `json:"map,omitempty"`
+}
+
+// DomainRecord is a DNS record a .bit domain resolves to.
+type DomainRecord struct {
+	Domain string `json:"domain"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+}
+
+// IdentityValue is the data of an id/ name. Fields the explorer does not
+// know are kept in Other.
+type IdentityValue struct {
+	Name     string                     `json:"name,omitempty"`
+	Nick     string                     `json:"nick,omitempty"`
+	Email    string                     `json:"email,omitempty"`
+	Website  string                     `json:"website,omitempty"`
+	Photo    string                     `json:"photo,omitempty"`
+	XMPP     string                     `json:"xmpp,omitempty"`
+	Bitcoin  string                     `json:"bitcoin,omitempty"`
+	Namecoin string                     `json:"namecoin,omitempty"`
+	GPG      *IdentityGPG               `json:"gpg,omitempty"`
+	Other    map[string]json.RawMessage `json:"other,omitempty"`
+}
+
+// IdentityGPG is the OpenPGP key of an identity.
+type IdentityGPG struct {
+	Version     string `json:"v,omitempty"`
+	Fingerprint string `json:"fpr,omitempty"`
+	URI         string `json:"uri,omitempty"`
+}
+
+// jsonObject is a JSON object with its members still undecoded.
+type jsonObject map[string]json.RawMessage
+
+// valueParser parses one name's value, collecting the problems it finds
+// along the way instead of giving up at the first.
+type valueParser struct {
+	ctx     context.Context
+	cc      *chainConfig
+	errs    []string
+	imports []string
+}
+
+func (p *valueParser) errorf(format string, args ...interface{}) {
+	p.errs = append(p.errs, fmt.Sprintf(format, args...))
+}
+
+// parseNameValue parses the value of a d/ or id/ name. Other names have no
+// parsed form and get nil.
+func parseNameValue(ctx context.Context, name []byte, value []byte, cc *chainConfig) *ParsedValue {
+	p := &valueParser{ctx: ctx, cc: cc}
+	var parsed ParsedValue
+	switch {
+	case bytes.HasPrefix(name, []byte(domainNamespace)):
+		parsed.Type = "domain"
+		label := string(name[len(domainNamespace):])
+		if !domainLabel.MatchString(label) {
+			p.errorf("%q is not a valid domain name", label)
+		}
+		if obj, ok := p.object(value); ok {
+			visited := map[string]bool{string(name): true}
+			parsed.Domain = p.domain(p.resolve(obj, 1, visited), "")
+			parsed.Records = make([]DomainRecord, 0)
+			expandRecords(label+".bit", parsed.Domain, &parsed.Records)
+		}
+	case bytes.HasPrefix(name, []byte(identityNamespace)):
+		parsed.Type = "identity"
+		if obj, ok := p.object(value); ok {
+			visited := map[string]bool{string(name): true}
+			parsed.Identity = p.identity(p.resolve(obj, 1, visited))
+		}
+	default:
+		return nil
+	}
+	parsed.Valid = len(p.errs) == 0
+	parsed.Errors = p.errs
+	parsed.Imports = p.imports
+	return &parsed
+}
+
+// object decodes a value that has to be a JSON object.
+func (p *valueParser) object(value []byte) (jsonObject, bool) {
+	if len(value) > nameMaxValueLength {
+		p.errorf("value is longer than %d bytes", nameMaxValueLength)
+		return nil, false
+	}
+	var obj jsonObject
+	if err := json.Unmarshal(value, &obj); err != nil {
+		p.errorf("invalid JSON: %v", err)
+		return nil, false
+	}
+	if obj == nil {
+		p.errorf("invalid JSON: not an object")
+		return nil, false
+	}
+	return obj, true
+}
+
+// nameRef is an import or delegate reference: a name, and the subdomain of
+// it to take, empty for the whole of it.
+type nameRef struct {
+	name     string
+	selector string
+}
+
+// resolve returns obj with its delegate and imports, and those of its
+// subdomains, replaced by the data they refer to. A delegate replaces
+// everything else; imported data is overridden by obj's own. visited holds
+// the names being resolved, to stop reference loops.
+func (p *valueParser) resolve(obj jsonObject, depth int, visited map[string]bool) jsonObject {
+	if raw, ok := obj["delegate"]; ok {
+		refs, err := parseNameRefs(raw, false)
+		if err != nil {
+			p.errorf("delegate: %v", err)
+			return jsonObject{}
+		}
+		delegated := p.fetch(refs[0], depth, visited)
+		if delegated == nil {
+			return jsonObject{}
+		}
+		return delegated
+	}
+
+	res := make(jsonObject)
+	if raw, ok := obj["import"]; ok {
+		refs, err := parseNameRefs(raw, true)
+		if err != nil {
+			p.errorf("import: %v", err)
+		}
+		for _, ref := range refs {
+			if imported := p.fetch(ref, depth, visited); imported != nil {
+				mergeObjects(res, imported)
+			}
+		}
+	}
+	own := make(jsonObject, len(obj))
+	for key, raw := range obj {
+		if key != "import" {
+			own[key] = raw
+		}
+	}
+	mergeObjects(res, own)
+
+	// Subdomains can have references of their own
+	if raw, ok := res["map"]; ok {
+		var entries jsonObject
+		if err := json.Unmarshal(raw, &entries); err == nil && entries != nil {
+			for sub, entryRaw := range entries {
+				var entry jsonObject
+				if err := json.Unmarshal(entryRaw, &entry); err != nil || entry == nil {
+					continue
+				}
+				entries[sub], _ = json.Marshal(p.resolve(entry, depth, visited))
+			}
+			res["map"], _ = json.Marshal(entries)
+		}
+	}
+	return res
+}
+
+// fetch returns the resolved value of the name a reference is to, or of the
+// subdomain of it the reference selects.
+func (p *valueParser) fetch(ref nameRef, depth int, visited map[string]bool) jsonObject {
+	switch {
+	case depth >= nameMaxImportDepth:
+		p.errorf("%s: references nested more than %d deep", ref.name, nameMaxImportDepth)
+		return nil
+	case visited[ref.name]:
+		p.errorf("%s: reference loop", ref.name)
+		return nil
+	}
+
+	state, err := getName(p.ctx, []byte(ref.name), p.cc)
+	switch {
+	case errors.Is(err, errNotFound):
+		p.errorf("%s: name not found", ref.name)
+		return nil
+	case err != nil:
+		p.errorf("%s: %v", ref.name, err)
+		return nil
+	case state.Expired:
+		p.errorf("%s: name has expired", ref.name)
+		return nil
+	}
+	p.imports = append(p.imports, ref.name)
+
+	obj, ok := p.object(state.valueBytes())
+	if !ok {
+		return nil
+	}
+	visited[ref.name] = true
+	obj = p.resolve(obj, depth+1, visited)
+	delete(visited, ref.name)
+
+	if ref.selector == "" {
+		return obj
+	}
+	sub := selectSubdomain(obj, ref.selector)
+	if sub == nil {
+		p.errorf("%s: no subdomain %q", ref.name, ref.selector)
+	}
+	return sub
+}
+
+// parseNameRefs reads an import or delegate member. Either is a name, or a
+// list of a name and a subdomain selector; imports can also be a list of
+// those.
+func parseNameRefs(raw json.RawMessage, many bool) ([]nameRef, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return []nameRef{{name: name}}, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, errors.New("expected a name or a list")
+	}
+	if ref, err := parseNameRef(list); err == nil {
+		return []nameRef{ref}, nil
+	} else if !many {
+		return nil, err
+	}
+
+	var refs []nameRef
+	for _, item := range list {
+		if err := json.Unmarshal(item, &name); err == nil {
+			refs = append(refs, nameRef{name: name})
+			continue
+		}
+		var pair []json.RawMessage
+		if err := json.Unmarshal(item, &pair); err != nil {
+			return refs, errors.New("expected a name or a list of a name and a subdomain")
+		}
+		ref, err := parseNameRef(pair)
+		if err != nil {
+			return refs, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// parseNameRef reads a [name] or [name, selector] list.
+func parseNameRef(list []json.RawMessage) (nameRef, error) {
+	var ref nameRef
+	if len(list) == 0 || len(list) > 2 || json.Unmarshal(list[0], &ref.name) != nil {
+		return ref, errors.New("expected a name and an optional subdomain")
+	}
+	if len(list) == 2 && json.Unmarshal(list[1], &ref.selector) != nil {
+		return ref, errors.New("expected the subdomain as a string")
+	}
+	return ref, nil
+}
+
+// selectSubdomain returns the subdomain of obj named by selector, such as
+// "www" or "a.b", or nil if it has none.
+func selectSubdomain(obj jsonObject, selector string) jsonObject {
+	labels := strings.Split(selector, ".")
+	for i := len(labels) - 1; i >= 0 && obj != nil; i-- {
+		var entries jsonObject
+		if json.Unmarshal(obj["map"], &entries) != nil {
+			return nil
+		}
+		var sub jsonObject
+		if json.Unmarshal(entries[labels[i]], &sub) != nil {
+			return nil
+		}
+		obj = sub
+	}
+	return obj
+}
+
+// mergeObjects copies the members of src into dst, replacing those there
+// except for the subdomains of map, which are merged one by one.
+func mergeObjects(dst, src jsonObject) {
+	for key, raw := range src {
+		if key == "map" {
+			var dstMap, srcMap jsonObject
+			if json.Unmarshal(dst["map"], &dstMap) == nil && dstMap != nil &&
+				json.Unmarshal(raw, &srcMap) == nil && srcMap != nil {
+				for sub, entry := range srcMap {
+					dstMap[sub] = entry
+				}
+				dst[key], _ = json.Marshal(dstMap)
+				continue
+			}
+		}
+		dst[key] = raw
+	}
+}
+
+// domain converts a resolved d/ value, or one of its subdomains at path, to
+// a DomainValue.
+func (p *valueParser) domain(obj jsonObject, path string) *DomainValue {
+	field := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return "map." + path + "." + key
+	}
+
+	var v DomainValue
+	for key, raw := range obj {
+		var err error
+		switch key {
+		case "ip":
+			v.IP, err = p.addresses(raw, false, field(key))
+		case "ip6":
+			v.IP6, err = p.addresses(raw, true, field(key))
+		case "ns":
+			v.NS, err = stringList(raw)
+		case "txt":
+			v.TXT, err = stringList(raw)
+		case "alias":
+			err = json.Unmarshal(raw, &v.Alias)
+		case "translate":
+			err = json.Unmarshal(raw, &v.Translate)
+		case "email":
+			err = json.Unmarshal(raw, &v.Email)
+		case "tor", "onion":
+			err = json.Unmarshal(raw, &v.Onion)
+		case "tls":
+			v.TLS = raw
+		case "ds":
+			v.DS = raw
+		case "info":
+			v.Info = raw
+		case "map":
+			var entries jsonObject
+			if err = json.Unmarshal(raw, &entries); err != nil {
+				break
+			}
+			v.Map = make(map[string]*DomainValue, len(entries))
+			for sub, entryRaw := range entries {
+				subPath := sub
+				if path != "" {
+					subPath = path + ".map." + sub
+				}
+				// A string is the old shorthand for an IPv4 address
+				var ip string
+				if json.Unmarshal(entryRaw, &ip) == nil {
+					entryRaw, _ = json.Marshal(jsonObject{"ip": json.RawMessage(entryRaw)})
+				}
+				var entry jsonObject
+				if json.Unmarshal(entryRaw, &entry) != nil || entry == nil {
+					p.errorf("%s: expected an object", field("map."+sub))
+					continue
+				}
+				v.Map[sub] = p.domain(entry, subPath)
+			}
+		}
+		if err != nil {
+			p.errorf("%s: %v", field(key), err)
+		}
+	}
+	return &v
+}
+
+// addresses reads the list of IPv4, or with v6 set IPv6, addresses in a
+// field, leaving out invalid ones.
+func (p *valueParser) addresses(raw json.RawMessage, v6 bool, field string) ([]string, error) {
+	list, err := stringList(raw)
+	if err != nil {
+		return nil, err
+	}
+	var valid []string
+	for _, s := range list {
+		ip := net.ParseIP(s)
+		if ip == nil || (ip.To4() == nil) != v6 {
+			p.errorf("%s: invalid address %q", field, s)
+			continue
+		}
+		valid = append(valid, s)
+	}
+	return valid, nil
+}
+
+// stringList reads a string or a list of strings.
+func stringList(raw json.RawMessage) ([]string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, errors.New("expected a string or a list of strings")
+	}
+	return list, nil
+}
+
+// identity converts a resolved id/ value to an IdentityValue.
+func (p *valueParser) identity(obj jsonObject) *IdentityValue {
+	var v IdentityValue
+	strs := map[string]*string{
+		"name": &v.Name, "nick": &v.Nick, "email": &v.Email, "website": &v.Website,
+		"photo": &v.Photo, "xmpp": &v.XMPP, "bitcoin": &v.Bitcoin, "namecoin": &v.Namecoin,
+	}
+	for key, raw := range obj {
+		if dst, ok := strs[key]; ok {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				p.errorf("%s: expected a string", key)
+			}
+			continue
+		}
+		if key == "gpg" {
+			v.GPG = new(IdentityGPG)
+			if err := json.Unmarshal(raw, v.GPG); err != nil {
+				p.errorf("gpg: expected an object of strings")
+				v.GPG = nil
+			}
+			continue
+		}
+		if v.Other == nil {
+			v.Other = make(map[string]json.RawMessage)
+		}
+		v.Other[key] = raw
+	}
+	return &v
+}
+
+// expandRecords appends the DNS records of domain, and then those of its
+// subdomains in order, to records.
+func expandRecords(domain string, v *DomainValue, records *[]DomainRecord) {
+	add := func(kind string, values ...string) {
+		for _, value := range values {
+			*records = append(*records, DomainRecord{Domain: domain, Type: kind, Value: value})
+		}
+	}
+	add("A", v.IP...)
+	add("AAAA", v.IP6...)
+	add("NS", v.NS...)
+	if v.Alias != "" {
+		add("CNAME", v.Alias)
+	}
+	if v.Translate != "" {
+		add("DNAME", v.Translate)
+	}
+	add("TXT", v.TXT...)
+
+	subs := make([]string, 0, len(v.Map))
+	for sub := range v.Map {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	for _, sub := range subs {
+		// The empty subdomain is the domain itself
+		subDomain := domain
+		if sub != "" {
+			subDomain = sub + "." + domain
+		}
+		expandRecords(subDomain, v.Map[sub], records)
+	}
+}
+
+// valueBytes returns the value of a name as it is on the chain.
+func (state NameState) valueBytes() []byte {
+	if state.ValueEncoding == nameEncodingHex {
+		value, _ := hex.DecodeString(state.Value)
+		return value
+	}
+	return []byte(state.Value)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// newTestNameChain returns a Namecoin regtest chain whose index has the given
+// names registered in its genesis block.
+func newTestNameChain(t *testing.T, names map[string]string) *chainConfig {
+	t.Helper()
+	cc := &chainConfig{name: "nmc", params: &nmcRegTestParams}
+	idx, err := openIndex(t.TempDir(), cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.db.Close() })
+	cc.index = idx
+
+	// Registered in order, so the txids do not depend on map order
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	owner := testOwnerScript(t)
+	block := BlockData{Hash: fmt.Sprintf("%064x", 1)}
+	for i, name := range sorted {
+		script := buildScript(t, txscript.OP_2, []byte(name), []byte{0x01, 0x02, 0x03}, []byte(names[name]),
+			txscript.OP_2DROP, txscript.OP_2DROP, rawBytes(owner))
+		block.Tx = append(block.Tx, TxData{
+			TxID: fmt.Sprintf("%064x", 100+i),
+			Vout: []VoutData{{Value: 0.01, ScriptPubKey: ScriptPubKeyData{Hex: hex.EncodeToString(script)}}},
+		})
+	}
+	if err := idx.addBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestParseDomainValue(t *testing.T) {
+	cc := newTestNameChain(t, map[string]string{
+		"d/base":   `{"ip":"192.0.2.1","map":{"www":{"ip6":"2001:db8::1"},"mail":{"ip":["192.0.2.2"]}}}`,
+		"d/loop-a": `{"import":"d/loop-b"}`,
+		"d/loop-b": `{"import":"d/loop-a","ns":"ns1.example."}`,
+		"d/deep1":  `{"import":"d/deep2"}`,
+		"d/deep2":  `{"import":"d/deep3"}`,
+		"d/deep3":  `{"import":"d/deep4"}`,
+		"d/deep4":  `{"ip":"192.0.2.9"}`,
+		"d/broken": `{"ip":`,
+	})
+
+	tests := []struct {
+		desc    string
+		name    string
+		value   string
+		valid   bool
+		errors  []string
+		imports []string
+		records []DomainRecord
+	}{
+		{
+			desc:  "addresses and subdomains",
+			name:  "d/example",
+			value: `{"ip":["192.0.2.1","192.0.2.2"],"ip6":"2001:db8::1","ns":"ns1.example.","txt":"hello","map":{"www":{"alias":"example.bit."},"*":{"ip":"192.0.2.3"}}}`,
+			valid: true,
+			records: []DomainRecord{
+				{"example.bit", "A", "192.0.2.1"},
+				{"example.bit", "A", "192.0.2.2"},
+				{"example.bit", "AAAA", "2001:db8::1"},
+				{"example.bit", "NS", "ns1.example."},
+				{"example.bit", "TXT", "hello"},
+				{"*.example.bit", "A", "192.0.2.3"},
+				{"www.example.bit", "CNAME", "example.bit."},
+			},
+		},
+		{
+			desc:  "empty subdomain, string shorthand and nested maps",
+			name:  "d/example",
+			value: `{"map":{"":{"ip":"192.0.2.1"},"a":{"map":{"b":"192.0.2.2"}},"c":{"translate":"other.bit."}}}`,
+			valid: true,
+			records: []DomainRecord{
+				{"example.bit", "A", "192.0.2.1"},
+				{"b.a.example.bit", "A", "192.0.2.2"},
+				{"c.example.bit", "DNAME", "other.bit."},
+			},
+		},
+		{
+			desc:    "import with own values taking precedence",
+			name:    "d/example",
+			value:   `{"import":[["d/base"]],"ip":"192.0.2.5","map":{"mail":{"ip":"192.0.2.6"}}}`,
+			valid:   true,
+			imports: []string{"d/base"},
+			records: []DomainRecord{
+				{"example.bit", "A", "192.0.2.5"},
+				{"mail.example.bit", "A", "192.0.2.6"},
+				{"www.example.bit", "AAAA", "2001:db8::1"},
+			},
+		},
+		{
+			desc:    "import of a subdomain",
+			name:    "d/example",
+			value:   `{"map":{"shop":{"import":[["d/base","www"]]}}}`,
+			valid:   true,
+			imports: []string{"d/base"},
+			records: []DomainRecord{{"shop.example.bit", "AAAA", "2001:db8::1"}},
+		},
+		{
+			desc:    "delegate replaces everything else",
+			name:    "d/example",
+			value:   `{"delegate":["d/base","mail"],"ip":"192.0.2.7"}`,
+			valid:   true,
+			imports: []string{"d/base"},
+			records: []DomainRecord{{"example.bit", "A", "192.0.2.2"}},
+		},
+		{
+			desc:    "missing import and subdomain",
+			name:    "d/example",
+			value:   `{"import":["d/missing",["d/base","nope"]],"ip":"192.0.2.1"}`,
+			errors:  []string{"d/missing: name not found", `d/base: no subdomain "nope"`},
+			imports: []string{"d/base"},
+			records: []DomainRecord{{"example.bit", "A", "192.0.2.1"}},
+		},
+		{
+			desc:    "import of invalid JSON",
+			name:    "d/example",
+			value:   `{"import":"d/broken"}`,
+			errors:  []string{"invalid JSON: unexpected end of JSON input"},
+			imports: []string{"d/broken"},
+			records: []DomainRecord{},
+		},
+		{
+			desc:    "reference loop",
+			name:    "d/loop-a",
+			value:   `{"import":"d/loop-b"}`,
+			errors:  []string{"d/loop-a: reference loop"},
+			imports: []string{"d/loop-b"},
+			records: []DomainRecord{{"loop-a.bit", "NS", "ns1.example."}},
+		},
+		{
+			desc:    "imports nested too deep",
+			name:    "d/example",
+			value:   `{"import":"d/deep1"}`,
+			errors:  []string{"d/deep4: references nested more than 4 deep"},
+			imports: []string{"d/deep1", "d/deep2", "d/deep3"},
+			records: []DomainRecord{},
+		},
+		{
+			desc:    "invalid fields",
+			name:    "d/example",
+			value:   `{"ip":["192.0.2.1","2001:db8::1","bad"],"ip6":"192.0.2.1","ns":5,"map":{"x":[1]}}`,
+			errors:  []string{`ip: invalid address "2001:db8::1"`, `ip: invalid address "bad"`, `ip6: invalid address "192.0.2.1"`, "map.x: expected an object", "ns: expected a string or a list of strings"},
+			records: []DomainRecord{{"example.bit", "A", "192.0.2.1"}},
+		},
+		{
+			desc:   "invalid JSON",
+			name:   "d/example",
+			value:  `{"ip":"192.0.2.1"`,
+			errors: []string{"invalid JSON: unexpected end of JSON input"},
+		},
+		{
+			desc:   "not an object",
+			name:   "d/example",
+			value:  `["192.0.2.1"]`,
+			errors: []string{"invalid JSON: json: cannot unmarshal array into Go value of type main.jsonObject"},
+		},
+		{
+			desc:   "null",
+			name:   "d/example",
+			value:  `null`,
+			errors: []string{"invalid JSON: not an object"},
+		},
+		{
+			desc:   "oversize value",
+			name:   "d/example",
+			value:  `{"txt":"` + strings.Repeat("x", nameMaxValueLength) + `"}`,
+			errors: []string{"value is longer than 1023 bytes"},
+		},
+		{
+			desc:    "invalid domain name",
+			name:    "d/Example",
+			value:   `{"ip":"192.0.2.1"}`,
+			errors:  []string{`"Example" is not a valid domain name`},
+			records: []DomainRecord{{"Example.bit", "A", "192.0.2.1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		parsed := parseNameValue(context.Background(), []byte(tt.name), []byte(tt.value), cc)
+		if parsed == nil || parsed.Type != "domain" {
+			t.Errorf("%s: got %+v, want a domain", tt.desc, parsed)
+			continue
+		}
+		sort.Strings(parsed.Errors)
+		sort.Strings(tt.errors)
+		if parsed.Valid != (len(tt.errors) == 0) || !reflect.DeepEqual(parsed.Errors, tt.errors) {
+			t.Errorf("%s: got valid %v, errors %q, want errors %q", tt.desc, parsed.Valid, parsed.Errors, tt.errors)
+		}
+		if !reflect.DeepEqual(parsed.Imports, tt.imports) {
+			t.Errorf("%s: got imports %q, want %q", tt.desc, parsed.Imports, tt.imports)
+		}
+		if !reflect.DeepEqual(parsed.Records, tt.records) {
+			t.Errorf("%s: got records %+v, want %+v", tt.desc, parsed.Records, tt.records)
+		}
+	}
+}
+
+func TestParseIdentityValue(t *testing.T) {
+	cc := newTestNameChain(t, map[string]string{
+		"id/base": `{"email":"base@example.com","website":"https://example.com"}`,
+	})
+
+	tests := []struct {
+		desc     string
+		value    string
+		errors   []string
+		identity *IdentityValue
+	}{
+		{
+			desc:  "known and unknown fields",
+			value: `{"name":"Alice","nick":"alice","bitcoin":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","gpg":{"v":"0","fpr":"ABCD","uri":"https://example.com/key"},"extra":[1]}`,
+			identity: &IdentityValue{
+				Name:    "Alice",
+				Nick:    "alice",
+				Bitcoin: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT",
+				GPG:     &IdentityGPG{Version: "0", Fingerprint: "ABCD", URI: "https://example.com/key"},
+				Other:   map[string]json.RawMessage{"extra": json.RawMessage(`[1]`)},
+			},
+		},
+		{
+			desc:     "import",
+			value:    `{"import":"id/base","email":"alice@example.com"}`,
+			identity: &IdentityValue{Email: "alice@example.com", Website: "https://example.com"},
+		},
+		{
+			desc:     "wrong types",
+			value:    `{"name":1,"gpg":"ABCD"}`,
+			errors:   []string{"gpg: expected an object of strings", "name: expected a string"},
+			identity: &IdentityValue{},
+		},
+		{
+			desc:   "invalid JSON",
+			value:  `{name:"Alice"}`,
+			errors: []string{"invalid JSON: invalid character 'n' looking for beginning of object key string"},
+		},
+		{
+			desc:   "oversize value",
+			value:  `{"name":"` + strings.Repeat("a", 2000) + `"}`,
+			errors: []string{"value is longer than 1023 bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		parsed := parseNameValue(context.Background(), []byte("id/alice"), []byte(tt.value), cc)
+		if parsed == nil || parsed.Type != "identity" {
+			t.Errorf("%s: got %+v, want an identity", tt.desc, parsed)
+			continue
+		}
+		sort.Strings(parsed.Errors)
+		if parsed.Valid != (len(tt.errors) == 0) || !reflect.DeepEqual(parsed.Errors, tt.errors) {
+			t.Errorf("%s: got valid %v, errors %q, want errors %q", tt.desc, parsed.Valid, parsed.Errors, tt.errors)
+		}
+		if !reflect.DeepEqual(parsed.Identity, tt.identity) {
+			t.Errorf("%s: got identity %+v, want %+v", tt.desc, parsed.Identity, tt.identity)
+		}
+	}
+}
+
+func TestParseOtherNamespaces(t *testing.T) {
+	cc := &chainConfig{name: "nmc", params: &nmcRegTestParams}
+	for _, name := range []string{"a/example", "dd/example", "example", "i/d"} {
+		if parsed := parseNameValue(context.Background(), []byte(name), []byte(`{}`), cc); parsed != nil {
+			t.Errorf("%s: got %+v, want nothing", name, parsed)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/hex"
+	"unicode/utf8"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Namecoin name operations, as Namecoin Core names them.
+const (
+	nameOpNew         = "name_new"
+	nameOpFirstUpdate = "name_firstupdate"
+	nameOpUpdate      = "name_update"
+)
+
+// Encodings of names and values in a NameOp. Data that is not valid UTF-8
+// is given in hex.
+const (
+	nameEncodingUTF8 = "utf8"
+	nameEncodingHex  = "hex"
+)
+
+// NameOp is the name operation carried by an output. NAME_NEW only commits
+// to a name through Hash; the name, value and the Rand salt behind the
+// commitment are revealed by the NAME_FIRSTUPDATE that registers it.
+// Address is the address owning the name after the operation.
+type NameOp struct {
+	Op            string `json:"op"`
+	Name          string `json:"name,omitempty"`
+	NameEncoding  string `json:"name_encoding,omitempty"`
+	Value         string `json:"value,omitempty"`
+	ValueEncoding string `json:"value_encoding,omitempty"`
+	Rand          string `json:"rand,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	Address       string `json:"address,omitempty"`
+}
+
+// nameScript is a scriptPubKey split into its name prefix and the ordinary
+// script paying the owner.
+type nameScript struct {
+	op         string
+	name       []byte
+	value      []byte
+	rand       []byte
+	hash       []byte
+	addrScript []byte
+}
+
+// decodeNameScript splits a scriptPubKey with a name prefix:
+//
+//	OP_NAME_NEW <hash> OP_2DROP <script>
+//	OP_NAME_FIRSTUPDATE <name> <rand> <value> OP_2DROP OP_2DROP <script>
+//	OP_NAME_UPDATE <name> <value> OP_2DROP OP_DROP <script>
+//
+// where the name opcodes are OP_1, OP_2 and OP_3. It reports false for
+// scripts without one.
+func decodeNameScript(script []byte) (nameScript, bool) {
+	var ns nameScript
+	var pushes int
+	var drops []byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	if !tokenizer.Next() {
+		return ns, false
+	}
+	switch tokenizer.Opcode() {
+	case txscript.OP_1:
+		ns.op, pushes, drops = nameOpNew, 1, []byte{txscript.OP_2DROP}
+	case txscript.OP_2:
+		ns.op, pushes, drops = nameOpFirstUpdate, 3, []byte{txscript.OP_2DROP, txscript.OP_2DROP}
+	case txscript.OP_3:
+		ns.op, pushes, drops = nameOpUpdate, 2, []byte{txscript.OP_2DROP, txscript.OP_DROP}
+	default:
+		return ns, false
+	}
+
+	var data [][]byte
+	for i := 0; i < pushes; i++ {
+		if !tokenizer.Next() || tokenizer.Opcode() > txscript.OP_PUSHDATA4 {
+			return ns, false
+		}
+		data = append(data, tokenizer.Data())
+	}
+	for _, drop := range drops {
+		if !tokenizer.Next() || tokenizer.Opcode() != drop {
+			return ns, false
+		}
+	}
+	ns.addrScript = script[tokenizer.ByteIndex():]
+
+	switch ns.op {
+	case nameOpNew:
+		ns.hash = data[0]
+	case nameOpFirstUpdate:
+		ns.name, ns.rand, ns.value = data[0], data[1], data[2]
+	case nameOpUpdate:
+		ns.name, ns.value = data[0], data[1]
+	}
+	return ns, true
+}
+
+// decodeNameOp returns the name operation of a hex scriptPubKey, if it has
+// one. Chains without names have none.
+func decodeNameOp(scriptHex string, cc *chainConfig) *NameOp {
+	if !cc.hasNames() {
+		return nil
+	}
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return nil
+	}
+	ns, ok := decodeNameScript(script)
+	if !ok {
+		return nil
+	}
+	op := ns.nameOp(cc.params)
+	return &op
+}
+
+func (ns nameScript) nameOp(params *chaincfg.Params) NameOp {
+	op := NameOp{
+		Op:      ns.op,
+		Rand:    hex.EncodeToString(ns.rand),
+		Hash:    hex.EncodeToString(ns.hash),
+		Address: ns.address(params),
+	}
+	if ns.op != nameOpNew {
+		op.Name, op.NameEncoding = encodeNameData(ns.name)
+		op.Value, op.ValueEncoding = encodeNameData(ns.value)
+	}
+	return op
+}
+
+// address returns the address of the script behind the name prefix, if it
+// pays to a single one.
+func (ns nameScript) address(params *chaincfg.Params) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(ns.addrScript, params)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
+
+// encodeNameData returns a name or value as a string and its encoding.
+func encodeNameData(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), nameEncodingUTF8
+	}
+	return hex.EncodeToString(data), nameEncodingHex
+}
+
+// addressScript returns the part of a script that pays its owner, which is
+// the whole script unless it has a name prefix. Only chains with names have
+// those; elsewhere the same opcodes mean something else.
+func addressScript(script []byte, cc *chainConfig) []byte {
+	if !cc.hasNames() {
+		return script
+	}
+	if ns, ok := decodeNameScript(script); ok {
+		return ns.addrScript
+	}
+	return script
+}
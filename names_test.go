@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+var testPkHash = bytes.Repeat([]byte{0x42}, 20)
+
+// testOwnerScript returns the P2PKH script name outputs in the tests pay to.
+func testOwnerScript(t *testing.T) []byte {
+	t.Helper()
+	script, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(testPkHash).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+// rawBytes is script that buildScript appends as it is.
+type rawBytes []byte
+
+// buildScript assembles a script from opcodes, given as ints, data pushes,
+// given as byte slices, and rawBytes.
+func buildScript(t *testing.T, parts ...interface{}) []byte {
+	t.Helper()
+	var script []byte
+	for _, part := range parts {
+		switch p := part.(type) {
+		case int:
+			script = append(script, byte(p))
+		case []byte:
+			pushed, err := txscript.NewScriptBuilder().AddFullData(p).Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			script = append(script, pushed...)
+		case rawBytes:
+			script = append(script, p...)
+		default:
+			t.Fatalf("unexpected script part %T", part)
+		}
+	}
+	return script
+}
+
+func TestDecodeNameScript(t *testing.T) {
+	owner := testOwnerScript(t)
+	hash := bytes.Repeat([]byte{0xab}, 20)
+	name := []byte("d/example")
+	rand := []byte{0x01, 0x02, 0x03, 0x04}
+	value := []byte(`{"ip":"1.2.3.4"}`)
+
+	tests := []struct {
+		desc   string
+		script []byte
+		ok     bool
+		want   nameScript
+	}{
+		{
+			desc:   "name_new",
+			script: buildScript(t, txscript.OP_1, hash, txscript.OP_2DROP, rawBytes(owner)),
+			ok:     true,
+			want:   nameScript{op: nameOpNew, hash: hash, addrScript: owner},
+		},
+		{
+			desc: "name_firstupdate",
+			script: buildScript(t, txscript.OP_2, name, rand, value,
+				txscript.OP_2DROP, txscript.OP_2DROP, rawBytes(owner)),
+			ok:   true,
+			want: nameScript{op: nameOpFirstUpdate, name: name, rand: rand, value: value, addrScript: owner},
+		},
+		{
+			desc:   "name_update",
+			script: buildScript(t, txscript.OP_3, name, value, txscript.OP_2DROP, txscript.OP_DROP, rawBytes(owner)),
+			ok:     true,
+			want:   nameScript{op: nameOpUpdate, name: name, value: value, addrScript: owner},
+		},
+		{
+			desc:   "name_update with an empty value",
+			script: buildScript(t, txscript.OP_3, name, txscript.OP_0, txscript.OP_2DROP, txscript.OP_DROP, rawBytes(owner)),
+			ok:     true,
+			want:   nameScript{op: nameOpUpdate, name: name, value: []byte{}, addrScript: owner},
+		},
+		{
+			desc:   "name_update with a long value",
+			script: buildScript(t, txscript.OP_3, name, bytes.Repeat([]byte("x"), 1023), txscript.OP_2DROP, txscript.OP_DROP, rawBytes(owner)),
+			ok:     true,
+			want:   nameScript{op: nameOpUpdate, name: name, value: bytes.Repeat([]byte("x"), 1023), addrScript: owner},
+		},
+		{
+			desc:   "plain P2PKH",
+			script: owner,
+		},
+		{
+			desc: "empty script",
+		},
+		{
+			desc:   "missing value push",
+			script: buildScript(t, txscript.OP_3, name, txscript.OP_2DROP, txscript.OP_DROP, rawBytes(owner)),
+		},
+		{
+			desc:   "wrong drops",
+			script: buildScript(t, txscript.OP_3, name, value, txscript.OP_DROP, txscript.OP_DROP, rawBytes(owner)),
+		},
+		{
+			desc:   "missing drops",
+			script: buildScript(t, txscript.OP_2, name, rand, value, txscript.OP_2DROP),
+		},
+		{
+			desc:   "truncated push",
+			script: buildScript(t, txscript.OP_3, rawBytes{0x09, 'd', '/'}),
+		},
+		{
+			desc:   "truncated pushdata1",
+			script: buildScript(t, txscript.OP_1, rawBytes{txscript.OP_PUSHDATA1}),
+		},
+		{
+			desc:   "name opcode alone",
+			script: buildScript(t, txscript.OP_1),
+		},
+	}
+
+	for _, tt := range tests {
+		got, ok := decodeNameScript(tt.script)
+		if ok != tt.ok {
+			t.Errorf("%s: got ok %v, want %v", tt.desc, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.op != tt.want.op || !bytes.Equal(got.name, tt.want.name) ||
+			!bytes.Equal(got.value, tt.want.value) || !bytes.Equal(got.rand, tt.want.rand) ||
+			!bytes.Equal(got.hash, tt.want.hash) || !bytes.Equal(got.addrScript, tt.want.addrScript) {
+			t.Errorf("%s: got %+v, want %+v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeNameOp(t *testing.T) {
+	owner := testOwnerScript(t)
+	nmc := &chainConfig{name: "nmc", params: &nmcRegTestParams}
+	btc := &chainConfig{name: "btc", params: &chaincfg.RegressionNetParams}
+	addr, err := btcutil.NewAddressPubKeyHash(testPkHash, &nmcRegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := hex.EncodeToString(buildScript(t, txscript.OP_3, []byte("d/example"), []byte{0xff, 0xfe},
+		txscript.OP_2DROP, txscript.OP_DROP, rawBytes(owner)))
+	op := decodeNameOp(update, nmc)
+	if op == nil {
+		t.Fatal("name_update not decoded")
+	}
+	want := NameOp{
+		Op:            nameOpUpdate,
+		Name:          "d/example",
+		NameEncoding:  nameEncodingUTF8,
+		Value:         "fffe",
+		ValueEncoding: nameEncodingHex,
+		Address:       addr.EncodeAddress(),
+	}
+	if *op != want {
+		t.Errorf("got %+v, want %+v", *op, want)
+	}
+
+	if op := decodeNameOp(update, btc); op != nil {
+		t.Errorf("bitcoin script decoded as %+v", *op)
+	}
+	if op := decodeNameOp(hex.EncodeToString(owner), nmc); op != nil {
+		t.Errorf("plain script decoded as %+v", *op)
+	}
+	if op := decodeNameOp("not hex", nmc); op != nil {
+		t.Errorf("invalid hex decoded as %+v", *op)
+	}
+
+	script, _ := hex.DecodeString(update)
+	if got := addressScript(script, nmc); !bytes.Equal(got, owner) {
+		t.Errorf("namecoin address script %x, want %x", got, owner)
+	}
+	if got := addressScript(script, btc); !bytes.Equal(got, script) {
+		t.Errorf("bitcoin address script %x, want the whole script", got)
+	}
+}
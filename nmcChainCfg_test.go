@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestNameExpiresAt(t *testing.T) {
+	tests := []struct {
+		network   string
+		params    *chaincfg.Params
+		height    int
+		expiresAt int
+	}{
+		{"mainnet", &nmcMainNetParams, 0, 12000},
+		{"mainnet", &nmcMainNetParams, 11999, 23999},
+		{"mainnet", &nmcMainNetParams, 12000, 24000},
+		{"mainnet", &nmcMainNetParams, 12001, 48001},
+		{"mainnet", &nmcMainNetParams, 30000, 66000},
+		{"mainnet", &nmcMainNetParams, 700000, 736000},
+		{"testnet", &nmcTestNetParams, 100, 12100},
+		{"testnet", &nmcTestNetParams, 50000, 86000},
+		{"regtest", &nmcRegTestParams, 0, 30},
+		{"regtest", &nmcRegTestParams, 1234, 1264},
+	}
+	for _, tt := range tests {
+		rules := nmcNetworkNameRules[tt.params]
+		if got := rules.expiresAt(tt.height); got != tt.expiresAt {
+			t.Errorf("%s: name at %d expires at %d, want %d", tt.network, tt.height, got, tt.expiresAt)
+		}
+	}
+}
+
+// TestNameExpiresAtMatchesCore checks expiresAt against Namecoin Core's own
+// test of whether a name is expired at a height.
+func TestNameExpiresAtMatchesCore(t *testing.T) {
+	for network, params := range chainNetworks["nmc"] {
+		rules := nmcNetworkNameRules[params]
+		for height := 0; height < 60000; height += 997 {
+			expired := func(h int) bool {
+				return height+rules.expirationDepth(h) <= h
+			}
+			at := rules.expiresAt(height)
+			if !expired(at) || expired(at-1) {
+				t.Errorf("%s: name at %d expires at %d, which disagrees with Core", network, height, at)
+			}
+		}
+	}
+}
+
+func TestNewNameStateExpiry(t *testing.T) {
+	op := indexedNameOp{Name: []byte("d/a"), Height: 100}
+	tests := []struct {
+		rules     *nameRules
+		tip       int
+		expiresIn int
+		expired   bool
+	}{
+		{&nmcRegTestNameRules, 129, 1, false},
+		{&nmcRegTestNameRules, 130, 0, true},
+		{&nmcMainNameRules, 12099, 1, false},
+		{&nmcMainNameRules, 12100, 0, true},
+	}
+	for _, tt := range tests {
+		state := newNameState(op, tt.tip, tt.rules)
+		if state.ExpiresIn != tt.expiresIn || state.Expired != tt.expired {
+			t.Errorf("tip %d, depth %d: got expires_in %d, expired %v", tt.tip,
+				tt.rules.maxExpirationDepth, state.ExpiresIn, state.Expired)
+		}
+	}
+}
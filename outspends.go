@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Outspend says whether an output has been spent and if so by which input.
+// Height is 0 while the spending transaction is unconfirmed.
+type Outspend struct {
+	Spent  bool   `json:"spent"`
+	TxID   string `json:"txid,omitempty"`
+	Vin    int    `json:"vin"`
+	Height int    `json:"height"`
+}
+
+// getOutspends returns the spends of every output of tx, by output index.
+// Confirmed spends come from the index once it has caught up, leaving only the
+// mempool to be checked with the Electrum server. Otherwise the histories of
+// the output scripts are searched for the spending transactions.
+func getOutspends(ctx context.Context, tx ElectrumTransaction, cc *chainConfig) ([]Outspend, error) {
+	outspends := make([]Outspend, len(tx.Vout))
+
+	mempoolOnly := cc.index.synced()
+	if mempoolOnly {
+		for n, spend := range cc.index.spends(tx.TxID) {
+			if n < len(outspends) {
+				outspends[n] = Outspend{Spent: true, TxID: spend.TxID, Vin: spend.Vin, Height: spend.Height}
+			}
+		}
+	}
+
+	var unspent []int
+	for n := range outspends {
+		if !outspends[n].Spent {
+			unspent = append(unspent, n)
+		}
+	}
+	if len(unspent) == 0 {
+		return outspends, nil
+	}
+
+	if err := findSpends(ctx, tx, unspent, mempoolOnly, outspends, cc); err != nil {
+		return nil, err
+	}
+	return outspends, nil
+}
+
+// findSpends looks for the spends of the given outputs of tx among the
+// transactions of their scripts, only the unconfirmed ones if mempoolOnly is
+// set, and fills them into outspends.
+func findSpends(ctx context.Context, tx ElectrumTransaction, outputs []int, mempoolOnly bool, outspends []Outspend, cc *chainConfig) error {
+	method := "blockchain.scripthash.get_history"
+	if mempoolOnly {
+		method = "blockchain.scripthash.get_mempool"
+	}
+
+	batch := cc.electrum.newBatch()
+	seen := make(map[string]bool)
+	var histories [][]HistoryTransaction
+	for _, n := range outputs {
+		script, err := hex.DecodeString(tx.Vout[n].ScriptPubKey.Hex)
+		if err != nil {
+			continue
+		}
+		sh := hex.EncodeToString(scriptHash(addressScript(script, cc)))
+		if seen[sh] {
+			continue
+		}
+		seen[sh] = true
+		histories = append(histories, nil)
+		batch.add(method, []any{sh}, &histories[len(histories)-1])
+	}
+	if len(histories) == 0 {
+		return nil
+	}
+
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return err
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+
+	// Spends can only come after the transaction itself
+	txHeight := 0
+	for _, history := range histories {
+		for _, h := range history {
+			if h.TxHash == tx.TxID && h.Height > 0 {
+				txHeight = h.Height
+			}
+		}
+	}
+	heights := make(map[string]int)
+	var candidates []string
+	for _, history := range histories {
+		for _, h := range history {
+			if h.TxHash == tx.TxID || (h.Height > 0 && h.Height < txHeight) {
+				continue
+			}
+			if _, ok := heights[h.TxHash]; !ok {
+				candidates = append(candidates, h.TxHash)
+			}
+			heights[h.TxHash] = h.Height
+		}
+	}
+
+	txs, err := getTxs(ctx, candidates, cc)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[int]bool, len(outputs))
+	for _, n := range outputs {
+		wanted[n] = true
+	}
+	for _, txid := range candidates {
+		for i, vin := range txs[txid].Vin {
+			if vin.TxID != tx.TxID || !wanted[vin.Vout] {
+				continue
+			}
+			// Unconfirmed transactions have a height of 0 or -1
+			height := heights[txid]
+			if height < 0 {
+				height = 0
+			}
+			outspends[vin.Vout] = Outspend{Spent: true, TxID: txid, Vin: i, Height: height}
+		}
+	}
+	return nil
+}
+
+// indexedOutspends returns the confirmed spends of the outputs of an indexed
+// transaction, without asking the Electrum server about the mempool.
+func indexedOutspends(rec indexedTx, cc *chainConfig) []Outspend {
+	outspends := make([]Outspend, len(rec.Tx.Vout))
+	for n, spend := range cc.index.spends(rec.Tx.TxID) {
+		if n < len(outspends) {
+			outspends[n] = Outspend{Spent: true, TxID: spend.TxID, Vin: spend.Vin, Height: spend.Height}
+		}
+	}
+	return outspends
+}
+
+// withOutspends returns a copy of vouts with their spends filled in. vouts
+// may be shared with the cache, so it is not changed.
+func withOutspends(vouts []FullVout, outspends []Outspend) []FullVout {
+	res := make([]FullVout, len(vouts))
+	copy(res, vouts)
+	for i := range res {
+		if n := res[i].Index; n < len(outspends) {
+			outspend := outspends[n]
+			res[i].Outspend = &outspend
+		}
+	}
+	return res
+}
+
+func outspendsReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
+
+		var req struct {
+			TxId string `json:"txid"`
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		serveOutspends(w, r, req.TxId, cc)
+	}
+}
+
+// outspendsGetReq handles GET /tx/{txid}/outspends.
+func outspendsGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveOutspends(w, r, mux.Vars(r)["txid"], cc)
+	}
+}
+
+func serveOutspends(w http.ResponseWriter, r *http.Request, txid string, cc *chainConfig) {
+	if !isHash(txid) {
+		writeError(w, http.StatusBadRequest, "Invalid txid")
+		return
+	}
+
+	tx, err := getTx(r.Context(), txid, cc)
+	if err != nil {
+		writeBackendError(w, err, "transaction")
+		return
+	}
+
+	outspends, err := getOutspends(r.Context(), tx, cc)
+	if err != nil {
+		writeBackendError(w, err, "outspends")
+		return
+	}
+	resJSON, err := json.Marshal(outspends)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
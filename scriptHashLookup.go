@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ScriptHashPage is the balance and history of an Electrum scripthash, for
+// scripts that have no address. History lists the unconfirmed transactions
+// first and then the confirmed ones, newest first.
+type ScriptHashPage struct {
+	ScriptHash string               `json:"scripthash"`
+	Balance    AddrBal              `json:"balance"`
+	History    []HistoryTransaction `json:"history"`
+}
+
+// getScriptHash returns the balance and history of a scripthash.
+func getScriptHash(ctx context.Context, scriptHash string, cc *chainConfig) (ScriptHashPage, error) {
+	history, _, err := getAddressHist(ctx, scriptHash, cc)
+	if err != nil {
+		return ScriptHashPage{}, err
+	}
+	// A script that was never paid is unknown to the chain
+	if len(history) == 0 {
+		return ScriptHashPage{}, errNotFound
+	}
+	balance, err := getAddressBal(ctx, scriptHash, cc)
+	if err != nil {
+		return ScriptHashPage{}, err
+	}
+	balance.UnconfirmedTxs = make([]UnconfirmedChange, 0)
+
+	// Unconfirmed transactions have a height of 0 or -1
+	sort.SliceStable(history, func(i, j int) bool {
+		a, b := history[i], history[j]
+		if (a.Height > 0) != (b.Height > 0) {
+			return b.Height > 0
+		}
+		return histBefore(a, b)
+	})
+	return ScriptHashPage{ScriptHash: scriptHash, Balance: balance, History: history}, nil
+}
+
+// scriptHashGetReq handles GET /scripthash/{hash}.
+func scriptHashGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit, err := requestUnit(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		hash := strings.ToLower(mux.Vars(r)["hash"])
+		if !isHash(hash) {
+			writeError(w, http.StatusBadRequest, "Invalid scripthash")
+			return
+		}
+
+		page, err := getScriptHash(r.Context(), hash, cc)
+		if err != nil {
+			writeBackendError(w, err, "scripthash")
+			return
+		}
+		resJSON, err := marshalWithUnit(page, unit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Error marshaling data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resJSON)
+	}
+}
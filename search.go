@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// Kinds of objects a search can find.
+const (
+	searchBlock      = "block"
+	searchTx         = "tx"
+	searchAddress    = "address"
+	searchScriptHash = "scripthash"
+	searchName       = "name"
+)
+
+// SearchMatch is an object a search query resolved to. Redirect is the path
+// of the object's page, /<chain>/<type>/<id>.
+type SearchMatch struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Redirect string `json:"redirect"`
+}
+
+// SearchResult lists everything a query matched, best match first. The best
+// match is also given at the top level.
+type SearchResult struct {
+	Query string `json:"query"`
+	SearchMatch
+	Matches []SearchMatch `json:"matches"`
+}
+
+func newSearchMatch(cc *chainConfig, kind string, id string) SearchMatch {
+	return SearchMatch{
+		Type:     kind,
+		ID:       id,
+		Redirect: "/" + cc.name + "/" + kind + "/" + url.PathEscape(id),
+	}
+}
+
+// search works out what a query refers to. A number is a block height, and
+// 64 hex digits are looked up as a block hash, a txid and a scripthash, in
+// that order. Anything else is tried as an address and then, on Namecoin, as
+// a name. Errors are only returned when a backend could not be asked.
+func search(ctx context.Context, query string, cc *chainConfig) (SearchResult, error) {
+	query = strings.TrimSpace(query)
+	res := SearchResult{Query: query, Matches: make([]SearchMatch, 0)}
+	if query == "" {
+		return res, nil
+	}
+
+	if height, err := strconv.Atoi(query); err == nil && height >= 0 {
+		tip, err := getTipHeight(ctx, cc)
+		if err != nil {
+			return res, err
+		}
+		if height <= tip {
+			hash, err := getBlockHash(ctx, height, cc)
+			if err != nil {
+				return res, err
+			}
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchBlock, hash))
+		}
+	}
+
+	if b, err := hex.DecodeString(query); err == nil && len(b) == 32 {
+		hash := strings.ToLower(query)
+
+		if _, ok := cc.index.block(hash); ok {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchBlock, hash))
+		} else if _, err := cc.rpc.getBlockHeader(ctx, hash); err == nil {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchBlock, hash))
+		} else if !isRPCError(err, rpcErrInvalidAddressOrKey) {
+			return res, err
+		}
+
+		if _, err := getTx(ctx, hash, cc); err == nil {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchTx, hash))
+		} else if !isNotFound(err) {
+			return res, err
+		}
+
+		var history []HistoryTransaction
+		if err := cc.electrum.call(ctx, "blockchain.scripthash.get_history", []any{hash}, &history); err != nil {
+			return res, err
+		}
+		if len(history) > 0 {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchScriptHash, hash))
+		}
+	}
+
+	if len(res.Matches) == 0 {
+		if addr, err := btcutil.DecodeAddress(query, cc.params); err == nil && addr.IsForNet(cc.params) {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchAddress, addr.EncodeAddress()))
+		}
+	}
+
+	if len(res.Matches) == 0 && cc.hasNames() {
+		_, err := getName(ctx, []byte(query), cc)
+		if err == nil {
+			res.Matches = append(res.Matches, newSearchMatch(cc, searchName, query))
+		} else if !errors.Is(err, errNotFound) {
+			return res, err
+		}
+	}
+
+	if len(res.Matches) > 0 {
+		res.SearchMatch = res.Matches[0]
+	}
+	return res, nil
+}
+
+func searchReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
+
+		var req struct {
+			Query string `json:"query"`
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		serveSearch(w, r, req.Query, cc)
+	}
+}
+
+// searchGetReq handles GET /search?q=.
+func searchGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveSearch(w, r, r.URL.Query().Get("q"), cc)
+	}
+}
+
+func serveSearch(w http.ResponseWriter, r *http.Request, query string, cc *chainConfig) {
+	res, err := search(r.Context(), query, cc)
+	if err != nil {
+		writeBackendError(w, err, "search results")
+		return
+	}
+	if len(res.Matches) == 0 {
+		writeError(w, http.StatusNotFound, "Nothing found")
+		return
+	}
+
+	resJSON, err := json.Marshal(res)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
@@ -1,47 +1,42 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
-	"github.com/davecgh/go-spew/spew"
+	"github.com/gorilla/mux"
 )
 
-// TODO: replace the implementations pf this with an interface{}["result"] instead to save on repetitive ElectrumResponse structs
-type ElectrumTransactionResponse struct {
-	JSONRPC string              `json:"jsonrpc"`
-	Result  ElectrumTransaction `json:"result"`
-	ID      int                 `json:"id"`
-}
-
 type AddrBalHistory struct {
-	Block   int     `json:"block"`
-	Balance float64 `json:"balance"`
+	Block   int    `json:"block"`
+	Balance Amount `json:"balance"`
 }
 
 type FullVin struct {
-	TxID    string  `json:"txid"`
-	Amount  float64 `json:"amount"`
-	Index   int     `json:"index"`
-	Address string  `json:"address"`
+	TxID    string `json:"txid"`
+	Amount  Amount `json:"amount"`
+	Index   int    `json:"index"`
+	Address string `json:"address"`
 }
 
 type FullVout struct {
-	Amount  float64 `json:"amount"`
-	Index   int     `json:"index"`
-	Address string  `json:"address"`
+	Amount   Amount    `json:"amount"`
+	Index    int       `json:"index"`
+	Address  string    `json:"address"`
+	Script   string    `json:"script"`
+	NameOp   *NameOp   `json:"nameop,omitempty"`
+	Outspend *Outspend `json:"outspend,omitempty"`
 }
 
 type FullHistTransaction struct {
@@ -51,31 +46,32 @@ type FullHistTransaction struct {
 	Height        int        `json:"height"`
 	Size          int        `json:"size"`
 	VSize         int        `json:"vsize"`
-	BalanceChange float64    `json:"balchange"`
+	BalanceChange Amount     `json:"balchange"`
 	Vin           []FullVin  `json:"vins"`
 	Vout          []FullVout `json:"vouts"`
 }
 
-type Response struct {
-	JSONRPC string               `json:"jsonrpc"`
-	Result  []HistoryTransaction `json:"result"`
-	ID      int                  `json:"id"`
-}
-
+// HistoryTransaction is an entry of an Electrum scripthash history. Fee is
+// only given for unconfirmed transactions.
 type HistoryTransaction struct {
 	TxHash string `json:"tx_hash"`
 	Height int    `json:"height"`
+	Fee    Amount `json:"fee,omitempty"`
 }
 
-type BalanceResponse struct {
-	JSONRPC string  `json:"jsonrpc"`
-	Result  AddrBal `json:"result"`
-	ID      int     `json:"id"`
+// AddrBal is an address's balance. UnconfirmedTxs breaks the unconfirmed
+// part down by transaction.
+type AddrBal struct {
+	Confirmed      Amount              `json:"confirmed"`
+	Unconfirmed    Amount              `json:"unconfirmed"`
+	UnconfirmedTxs []UnconfirmedChange `json:"unconfirmedtxs"`
 }
 
-type AddrBal struct {
-	Confirmed   int64 `json:"confirmed"`
-	Unconfirmed int64 `json:"unconfirmed"`
+// UnconfirmedChange is what an unconfirmed transaction does to an address's
+// balance.
+type UnconfirmedChange struct {
+	TxID   string `json:"txid"`
+	Change Amount `json:"change"`
 }
 
 type BlockData struct {
@@ -160,12 +156,12 @@ type ParentBlockData struct {
 type HomeBlock struct {
 	Height      int     `json:"height"`
 	Hash        string  `json:"hash"`
-	Fees        float32 `json:"fees"`
-	BlockReward float32 `json:"blockreward"`
+	Fees        Amount  `json:"fees"`
+	BlockReward Amount  `json:"blockreward"`
 	Size        float32 `json:"size"`
 	BlockTime   int32   `json:"blocktime"`
 	TxCount     int     `json:"txcount"`
-	BlockValue  float32 `json:"blockvalue"`
+	BlockValue  Amount  `json:"blockvalue"`
 }
 
 type ElectrumTransaction struct {
@@ -211,8 +207,8 @@ type ElectrumScriptPubKeyData struct {
 }
 
 type HomeBlockTrend struct {
-	TxCount    int     `json:"txcount"`
-	BlockValue float32 `json:"blockvalue"`
+	TxCount    int    `json:"txcount"`
+	BlockValue Amount `json:"blockvalue"`
 }
 
 type FullBlock struct {
@@ -246,53 +242,6 @@ type FullTransaction struct {
 	Vout   []FullVout `json:"vouts"`
 }
 
-func createElectrumRequest(method string, params []interface{}) string {
-	// Create a map for the JSON-RPC request
-	request := map[string]interface{}{
-		"id":      3,
-		"jsonrpc": "2.0",
-		"method":  method,
-		"params":  params,
-	}
-
-	// Marshal the map into a JSON string
-	jsonRequest, err := json.Marshal(request)
-	if err != nil {
-		fmt.Println("Error creating JSON-RPC request:", err)
-		return ""
-	}
-
-	return string(jsonRequest) + "\n" // Ensure the request ends with a newline character
-
-}
-
-// TODO: add error handling
-func sendElectrumRequest(jsonRequest string) string {
-	// Connect to the server
-	conn, err := net.Dial("tcp", electrumURL)
-	if err != nil {
-		fmt.Println("Error connecting to the server:", err)
-		return ""
-	}
-	defer conn.Close()
-
-	// Send the JSON-RPC request to the server
-	_, err = fmt.Fprintf(conn, jsonRequest)
-	if err != nil {
-		fmt.Println("Error sending JSON-RPC request:", err)
-		return ""
-	}
-
-	// Read the server's response
-	response, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		fmt.Println("Error reading server response:", err)
-		return ""
-	}
-
-	return response
-}
-
 // Based on the following discussion of electrum scripthashing:
 // https://github.com/bitcoinjs/bitcoinjs-lib/issues/990
 func ElectrumScripthash(addressStr string, chainParams *chaincfg.Params) (string, error) {
@@ -309,42 +258,67 @@ func ElectrumScripthash(addressStr string, chainParams *chaincfg.Params) (string
 		return "", err
 	}
 
+	return hex.EncodeToString(scriptHash(script)), nil
+}
+
+// scriptHash returns the Electrum scripthash of a script: its SHA-256 with
+// the bytes reversed. Output scripts should go through addressScript first,
+// as the Electrum servers leave out Namecoin name prefixes so that name
+// outputs are in the history of their address.
+func scriptHash(script []byte) []byte {
 	sum := sha256.Sum256(script)
 	length := len(sum)
 	for i := 0; i < length/2; i++ {
 		// Swap arr[i] with arr[length-i-1]
 		sum[i], sum[length-i-1] = sum[length-i-1], sum[i]
 	}
-	return hex.EncodeToString(sum[:]), nil
+	return sum[:]
 }
 
-// TODO: implement go channels for multi-threading the vin process (requires a lot of electrum requests)
-// Current implementation will be pretty slow due to single threaded iteration
-func parseBlockTxs(txs []TxData, port int) (float32 /*reward*/, float32 /*fees*/, float32 /*value*/, error /*error*/) {
-	var reward float32 = 0.0
-	var fees float32 = 0.0
-	var value float32 = 0.0
+// parseBlockTxs totals the block reward, fees and value of a block's
+// transactions. The inputs of all transactions are resolved concurrently.
+func parseBlockTxs(ctx context.Context, txs []TxData, cc *chainConfig) (Amount /*reward*/, Amount /*fees*/, Amount /*value*/, error /*error*/) {
+	var reward Amount
+	var fees Amount
+	var value Amount
+
+	// Resolve the inputs of all transactions up front
+	seen := make(map[string]bool)
+	var txids []string
+	for _, tx := range txs {
+		for _, vin := range tx.Vin {
+			if vin.TxID != "" && !seen[vin.TxID] {
+				seen[vin.TxID] = true
+				txids = append(txids, vin.TxID)
+			}
+		}
+	}
+	prevTxs, err := fetchTxsConcurrently(ctx, txids, cc)
+	if err != nil {
+		return 0, 0, 0, err
+	}
 
 	for _, tx := range txs {
-		vinVal := 0.0
-		voutVal := 0.0
+		var vinVal Amount
+		var voutVal Amount
 
 		for _, vout := range tx.Vout {
-			voutVal += vout.Value
+			voutVal += toSats(vout.Value)
 		}
 
 		if len(tx.Vin) == 1 && tx.Vin[0].TxID == "" { //Block Reward Tx
-			reward += float32(voutVal)
-			value += float32(voutVal) // rewards don't have vin or fee but do contribute to block tx value
+			reward += voutVal
+			value += voutVal // rewards don't have vin or fee but do contribute to block tx value
 		} else { //Regular Transaction
 			for _, vin := range tx.Vin {
-				temp, _ := getTx(vin.TxID, port)
-				spew.Dump(tx.Vin)
-				fmt.Println("float: ", vin.Vout, " int: ", int(vin.Vout))
-				vinVal += temp.Vout[int(vin.Vout)].Value
+				prevTx := prevTxs[vin.TxID]
+				if int(vin.Vout) >= len(prevTx.Vout) {
+					return 0, 0, 0, fmt.Errorf("input %s:%d of %s not found", vin.TxID, int(vin.Vout), tx.TxID)
+				}
+				vinVal += toSats(prevTx.Vout[int(vin.Vout)].Value)
 			}
-			fees += float32(vinVal - voutVal)
-			value += float32(vinVal)
+			fees += vinVal - voutVal
+			value += vinVal
 		}
 	}
 
@@ -352,47 +326,214 @@ func parseBlockTxs(txs []TxData, port int) (float32 /*reward*/, float32 /*fees*/
 	return reward, fees, value, nil
 }
 
-func getTx(txid string, port int) (ElectrumTransaction, error) {
+// fetchTxsConcurrently fetches txids with up to cc.PrevoutWorkers batches in
+// flight at once. Unlike getTxs it fails, and stops fetching, as soon as any
+// transaction can not be fetched.
+func fetchTxsConcurrently(ctx context.Context, txids []string, cc *chainConfig) (map[string]ElectrumTransaction, error) {
+	workers := cc.PrevoutWorkers
+	if workers > len(txids) {
+		workers = len(txids)
+	}
+	if workers == 0 {
+		return map[string]ElectrumTransaction{}, nil
+	}
+
+	// Split the work so every worker gets something to do, without making
+	// batches bigger than the client sends at once anyway
+	chunkSize := (len(txids) + workers - 1) / workers
+	if chunkSize > electrumMaxBatch {
+		chunkSize = electrumMaxBatch
+	}
+	chunks := make(chan []string)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		txs      = make(map[string]ElectrumTransaction, len(txids))
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				found, err := getTxs(ctx, chunk, cc)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				for _, txid := range chunk {
+					if _, ok := found[txid]; !ok {
+						fail(fmt.Errorf("transaction %s could not be fetched", txid))
+						break
+					}
+				}
+
+				mu.Lock()
+				for txid, tx := range found {
+					txs[txid] = tx
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for start := 0; start < len(txids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(txids) {
+			end = len(txids)
+		}
+		select {
+		case chunks <- txids[start:end]:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// The caller's context may have ended without any worker noticing
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// getTx fetches a transaction, from the cache or the index if possible. The confirmation
+// count of a cached transaction is the one it had when it was fetched.
+func getTx(ctx context.Context, txid string, cc *chainConfig) (ElectrumTransaction, error) {
+	if cached, ok := cc.cache.get(cacheTx, txid); ok {
+		return cached.(ElectrumTransaction), nil
+	}
+	if rec, ok := cc.index.tx(txid); ok {
+		cc.cache.add(cacheTx, txid, rec.Tx, 1, rec.Tx.BlockHash)
+		return rec.Tx, nil
+	}
+
 	params := []any{txid, true} // false=rawTx, true=verboseTx
-	reqJSON := createElectrumRequest("blockchain.transaction.get", params)
-	res := sendElectrumRequest(reqJSON)
 
-	var response ElectrumTransactionResponse
-	err := json.Unmarshal([]byte(res), &response)
+	var tx ElectrumTransaction
+	err := cc.electrum.call(ctx, "blockchain.transaction.get", params, &tx)
 	if err != nil {
 		return ElectrumTransaction{}, err
 	}
-	return response.Result, nil
+	cc.cache.add(cacheTx, txid, tx, 1, tx.BlockHash)
+	return tx, nil
+}
+
+// getTxs fetches the given transactions in batches and returns them by txid.
+// Duplicate txids are only fetched once, and cached or indexed ones not at
+// all. It fails if any of them cannot be fetched.
+func getTxs(ctx context.Context, txids []string, cc *chainConfig) (map[string]ElectrumTransaction, error) {
+	txs := make(map[string]ElectrumTransaction, len(txids))
+	batch := cc.electrum.newBatch()
+	results := make(map[string]*ElectrumTransaction, len(txids))
+	order := make([]string, 0, len(txids))
+	for _, txid := range txids {
+		if _, ok := results[txid]; ok {
+			continue
+		}
+		if _, ok := txs[txid]; ok {
+			continue
+		}
+		if cached, ok := cc.cache.get(cacheTx, txid); ok {
+			txs[txid] = cached.(ElectrumTransaction)
+			continue
+		}
+		if rec, ok := cc.index.tx(txid); ok {
+			txs[txid] = rec.Tx
+			cc.cache.add(cacheTx, txid, rec.Tx, 1, rec.Tx.BlockHash)
+			continue
+		}
+		tx := new(ElectrumTransaction)
+		results[txid] = tx
+		order = append(order, txid)
+		batch.add("blockchain.transaction.get", []any{txid, true}, tx)
+	}
+
+	if len(order) == 0 {
+		return txs, nil
+	}
+
+	errs, err := batch.send(ctx)
+	if err != nil {
+		return txs, err
+	}
+	for i, txid := range order {
+		if errs[i] != nil {
+			return nil, &backendError{sourceElectrum, fmt.Errorf("fetching transaction %s: %v", txid, errs[i])}
+		}
+		txs[txid] = *results[txid]
+		cc.cache.add(cacheTx, txid, txs[txid], 1, txs[txid].BlockHash)
+	}
+	return txs, nil
 }
 
-func loadHome(coin string) ([]HomeBlock, []HomeBlockTrend, error) {
-	port := 0
-	if coin == "nmc" {
-		port = nmcPort
-	} else if coin == "btc" {
-		port = btcPort
-	} else {
-		return []HomeBlock{}, []HomeBlockTrend{}, fmt.Errorf("invalid coin") // Error
+// getPrevTxs fetches the transactions spent by the inputs of txs, all in one
+// batched lookup.
+func getPrevTxs(ctx context.Context, txs []ElectrumTransaction, cc *chainConfig) (map[string]ElectrumTransaction, error) {
+	var txids []string
+	for _, tx := range txs {
+		for _, vin := range tx.Vin {
+			// Block Rewards won't have a TxId
+			if vin.TxID != "" {
+				txids = append(txids, vin.TxID)
+			}
+		}
 	}
 
-	// Get BlockCount
-	blockHeight, err := getBlockHeight(port)
+	return getTxs(ctx, txids, cc)
+}
 
+func loadHome(ctx context.Context, cc *chainConfig) ([]HomeBlock, []HomeBlockTrend, error) {
+	// Get BlockCount
+	blockHeight, err := getBlockHeight(ctx, cc)
 	if err != nil {
-		fmt.Println("Error getting current blockheight")
+		return nil, nil, err
 	}
 
 	fmt.Println("Blockheight: ", blockHeight)
 
+	// Get 10 Latest Blocks, fetching the hashes and then the blocks in one
+	// batch each
+	var heights []int
+	for i := 0; i < 10 && blockHeight-i >= 0; i++ {
+		heights = append(heights, blockHeight-i)
+	}
+	blockHashes, err := cc.rpc.getBlockHashes(ctx, heights)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return []HomeBlock{}, []HomeBlockTrend{}, err
+	}
+	blocks, err := getBlocks(ctx, blockHashes, cc)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return []HomeBlock{}, []HomeBlockTrend{}, err
+	}
+
 	var newestBlocks []HomeBlock
 	var homeTrends []HomeBlockTrend
-	// Get 10 Latest Blocks
-	for i := 0; i < 10; i++ {
-		blockHash, _ := getBlockHash((blockHeight - i), nmcPort)
-		fmt.Println(blockHash)
-		block, _ := getBlock(blockHash, nmcPort)
-		fmt.Println(block.Height)
-		r, f, v, _ := parseBlockTxs(block.Tx, port)
+	for _, block := range blocks {
+		r, f, v, err := parseBlockTxs(ctx, block.Tx, cc)
+		if err != nil {
+			return nil, nil, err
+		}
 		// Add block to block list
 		temp := HomeBlock{
 			Height:      int(block.Height),
@@ -411,211 +552,243 @@ func loadHome(coin string) ([]HomeBlock, []HomeBlockTrend, error) {
 	return newestBlocks, homeTrends, nil
 }
 
-func getBlock(hash string, portNum int) (BlockData, error) {
-
-	method := "getblock"
-	params := []interface{}{hash, 2} // verbosity = 2 includes all transactions in block
+// getBlock fetches a block, from the cache if possible.
+func getBlock(ctx context.Context, hash string, cc *chainConfig) (BlockData, error) {
+	if cached, ok := cc.cache.get(cacheBlock, hash); ok {
+		if confs, ok := checkCachedBlocks(ctx, []string{hash}, cc)[hash]; ok {
+			block := cached.(BlockData)
+			block.Confirmations = confs
+			return block, nil
+		}
+	}
 
-	result, err := makeRPCRequest(method, params, nmcPort)
+	block, err := cc.rpc.getBlock(ctx, hash)
 	if err != nil {
 		fmt.Println("Error:", err)
 		return BlockData{}, err
 	}
+	cacheBlockData(block, cc)
 
-	// Convert the provided data to the MyStruct type
-	var myStruct BlockData
-	dataJSON, err := json.Marshal(result)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return BlockData{}, err
+	return block, nil
+}
+
+// getBlocks is getBlock for several blocks, fetching the ones that are not
+// cached in one batch.
+func getBlocks(ctx context.Context, hashes []string, cc *chainConfig) ([]BlockData, error) {
+	blocks := make([]BlockData, len(hashes))
+	var cachedHashes []string
+	for i, hash := range hashes {
+		if block, ok := cc.cache.get(cacheBlock, hash); ok {
+			blocks[i] = block.(BlockData)
+			cachedHashes = append(cachedHashes, hash)
+		}
+	}
+	confs := checkCachedBlocks(ctx, cachedHashes, cc)
+
+	var missing []string
+	var missingIdx []int
+	for i, hash := range hashes {
+		if c, ok := confs[hash]; ok {
+			blocks[i].Confirmations = c
+			continue
+		}
+		missing = append(missing, hash)
+		missingIdx = append(missingIdx, i)
+	}
+	if len(missing) == 0 {
+		return blocks, nil
 	}
 
-	err = json.Unmarshal(dataJSON, &myStruct)
+	fetched, err := cc.rpc.getBlocks(ctx, missing)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return BlockData{}, err
+		return nil, err
 	}
-
-	return myStruct, nil
+	for i, block := range fetched {
+		blocks[missingIdx[i]] = block
+		cacheBlockData(block, cc)
+	}
+	return blocks, nil
 }
 
-func getBlockHash(height int, portNum int) (string, error) {
+// cacheBlockData caches a block if it is in the main chain. Blocks that were
+// reorganised out have a negative confirmation count.
+func cacheBlockData(block BlockData, cc *chainConfig) {
+	if block.Confirmations > 0 {
+		cc.cache.add(cacheBlock, block.Hash, block, len(block.Tx), block.Hash)
+	}
+}
 
-	method := "getblockhash"
-	params := []interface{}{height}
+// checkCachedBlocks returns the current confirmation counts of those of the
+// cached blocks hashes that are still in the main chain; the counts in the
+// cached copies are the ones they had when they were fetched. Anything cached
+// from blocks that have been reorganised out is dropped.
+func checkCachedBlocks(ctx context.Context, hashes []string, cc *chainConfig) map[string]float64 {
+	confs := make(map[string]float64, len(hashes))
+	if len(hashes) == 0 {
+		return confs
+	}
 
-	result, err := makeRPCRequest(method, params, nmcPort)
+	headers, err := cc.rpc.getBlockHeaders(ctx, hashes)
 	if err != nil {
 		fmt.Println("Error:", err)
-		return "", err
+		return confs
 	}
-
-	return fmt.Sprint(result), nil
+	for _, header := range headers {
+		if header.Confirmations < 0 {
+			cc.cache.dropBlock(header.Hash)
+			continue
+		}
+		confs[header.Hash] = float64(header.Confirmations)
+	}
+	return confs
 }
 
-func getBlockHeight(portNum int) (int, error) {
-
-	method := "getblockcount"
-	params := []interface{}{}
+func getBlockHash(ctx context.Context, height int, cc *chainConfig) (string, error) {
+	if hash, ok := cc.index.blockHash(height); ok {
+		return hash, nil
+	}
 
-	result, err := makeRPCRequest(method, params, nmcPort)
+	hash, err := cc.rpc.getBlockHash(ctx, height)
 	if err != nil {
 		fmt.Println("Error:", err)
-		return 0, err
+		return "", err
 	}
 
-	if val, ok := result.(float64); ok {
-		return int(val), nil
-	} else {
-		return 0, fmt.Errorf("int conversion failed")
-	}
+	return hash, nil
 }
 
-func nmcLoadHomeReq(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// getTipHeight returns the height of the best block as the tip follower last
+// saw it, so that it agrees with any reorg the follower has handled.
+func getTipHeight(ctx context.Context, cc *chainConfig) (int, error) {
+	if tip, ok := cc.follower.tip(); ok {
+		return tip.Height, nil
 	}
+	return getBlockHeight(ctx, cc)
+}
 
-	blocks, trends, _ := loadHome("nmc")
-
-	var res struct {
-		Blocks []HomeBlock      `json:"blocks"`
-		Trends []HomeBlockTrend `json:"trends"`
-	}
-
-	res.Blocks = blocks
-	res.Trends = trends
-
-	resJSON, err := json.Marshal(res)
+func getBlockHeight(ctx context.Context, cc *chainConfig) (int, error) {
+	height, err := cc.rpc.getBlockCount(ctx)
 	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
-		return
+		fmt.Println("Error:", err)
+		return 0, err
 	}
-	fmt.Println()
-	// Set headers and write JSON to response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resJSON)
+
+	return height, nil
 }
 
-// method := "getblockhash"
-// params := []interface{}{250, []interface{}{"minfeerate", "avgfeerate"}}
+// loadHomeReq takes GET as well as POST, since it has no parameters besides
+// the unit.
+func loadHomeReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
 
-// method := "getblockhash"
-// params := []interface{}{250}
+		unit, err := requestUnit(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 
-// result, err := makeRPCRequest(method, params)
-// if err != nil {
-// 	fmt.Println("Error:", err)
-// 	return
-// }
+		blocks, trends, err := loadHome(r.Context(), cc)
+		if err != nil {
+			writeBackendError(w, err, "latest blocks")
+			return
+		}
 
-// fmt.Println("Result:", result)
-// Sends a RPC request to the local core
-func makeRPCRequest(method string, params []interface{}, portNum int) (interface{}, error) {
-	// Set the RPC credentials
-	username := "rpc"
-	password := "rpc"
+		var res struct {
+			Blocks []HomeBlock      `json:"blocks"`
+			Trends []HomeBlockTrend `json:"trends"`
+		}
 
-	// Create the RPC request JSON
-	requestBody := map[string]interface{}{
-		"jsonrpc": "1.0",
-		"id":      "curltest",
-		"method":  method,
-		"params":  params,
-	}
+		res.Blocks = blocks
+		res.Trends = trends
 
-	// Marshal the request JSON
-	requestJSON, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
+		resJSON, err := marshalWithUnit(res, unit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Error marshaling data")
+			return
+		}
+		fmt.Println()
+		// Set headers and write JSON to response
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resJSON)
 	}
+}
 
-	// Encode the credentials for Basic Authentication
-	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+// postHandler is a dedicated function to handle POST requests to "/post".
+func addressReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
 
-	// Prepare the HTTP request
-	req, err := http.NewRequest("POST", coreURL+":"+fmt.Sprint(portNum), bytes.NewBuffer(requestJSON))
-	if err != nil {
-		return nil, err
-	}
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
 
-	// Set headers
-	req.Header.Set("Content-Type", "text/plain")
-	req.Header.Set("Authorization", "Basic "+auth)
+		// Define a struct to unmarshal the JSON data
+		var req struct {
+			Address string `json:"address"`
+			AddressQuery
+		}
 
-	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		// Unmarshal the JSON data
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
 
-	// Read and parse the response JSON
-	var response map[string]interface{}
-	decoder := json.NewDecoder(resp.Body)
-	if err := decoder.Decode(&response); err != nil {
-		return nil, err
+		serveAddress(w, r, req.Address, req.AddressQuery, cc)
 	}
+}
 
-	// Check for RPC error
-	if response["error"] != nil {
-		return nil, fmt.Errorf("RPC error: %v", response["error"])
+// addressGetReq handles GET /address/{addr}, with the AddressQuery fields as
+// query parameters.
+func addressGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := addressQueryFromURL(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid query: "+err.Error())
+			return
+		}
+		serveAddress(w, r, mux.Vars(r)["addr"], q, cc)
 	}
-
-	// Extract the result
-	result := response["result"]
-
-	return result, nil
 }
 
-// postHandler is a dedicated function to handle POST requests to "/post".
-func nmcAddressReq(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func serveAddress(w http.ResponseWriter, r *http.Request, addr string, q AddressQuery, cc *chainConfig) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Read the request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+	if _, err := btcutil.DecodeAddress(addr, cc.params); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid address")
 		return
 	}
-
-	// Define a struct to unmarshal the JSON data
-	var req struct {
-		Address string `json:"address"`
+	if err := q.validate(unit); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Request Body: "+err.Error())
+		return
 	}
 
-	// Unmarshal the JSON data
-	err = json.Unmarshal(body, &req)
+	response, err := getAddress(r.Context(), addr, q, cc)
 	if err != nil {
-		http.Error(w, "Error unmarshaling JSON data", http.StatusBadRequest)
+		writeBackendError(w, err, "address")
 		return
 	}
 
-	fmt.Println(req.Address)
-
-	transactionHistory, balanceHistory, balance := getAddress(req.Address, "nmc")
-
-	type res struct {
-		Balance        AddrBal               `json:"balance"`
-		TxHistory      []FullHistTransaction `json:"txhistory"`
-		BalanceHistory []AddrBalHistory      `json:"balancehistory"`
-	}
-
-	response := res{
-		Balance:        balance,
-		TxHistory:      transactionHistory,
-		BalanceHistory: balanceHistory,
-	}
 	// // Marshal the struct into JSON
-	resJSON, err := json.Marshal(response)
+	resJSON, err := marshalWithUnit(response, unit)
 	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
 		return
 	}
 
@@ -625,59 +798,152 @@ func nmcAddressReq(w http.ResponseWriter, r *http.Request) {
 	w.Write(resJSON)
 }
 
-func getAddress(addr string, chain string) ([]FullHistTransaction, []AddrBalHistory, AddrBal) {
-	var scriptHash string
-	if chain == "nmc" {
-		var err error
-		scriptHash, err = ElectrumScripthash(addr, &nmcMainnetChainParams)
-		fmt.Println("Error: ", err)
-	} else if chain == "btc" {
-		scriptHash, _ = ElectrumScripthash(addr, &chaincfg.MainNetParams)
+// getAddress returns the page of an address's confirmed history selected by
+// q, along with all of its unconfirmed transactions. Only the transactions on
+// the page are fetched, unless filtering by direction or amount needs the
+// balance changes of ones the index does not have.
+func getAddress(ctx context.Context, addr string, q AddressQuery, cc *chainConfig) (AddressPage, error) {
+	scriptHash, err := ElectrumScripthash(addr, cc.params)
+	if err != nil {
+		return AddressPage{}, err
+	}
+
+	histTxs, changes, err := getAddressHist(ctx, scriptHash, cc)
+	if err != nil {
+		return AddressPage{}, err
+	}
+	// An address that was never paid is unknown to the chain
+	if len(histTxs) == 0 {
+		return AddressPage{}, errNotFound
+	}
+	balance, err := getAddressBal(ctx, scriptHash, cc)
+	if err != nil {
+		return AddressPage{}, err
+	}
+	page := AddressPage{
+		Balance:   balance,
+		Pending:   make([]PendingTransaction, 0),
+		TxHistory: make([]FullHistTransaction, 0),
 	}
-	fmt.Println(chain, ": ", scriptHash)
 
-	histTxs := getAddressHist(scriptHash)
-	fmt.Println("++++++++++++++++++++++++++++++==")
-	addrBal := getAddressBal(scriptHash)
-	spew.Dump(histTxs, addrBal)
-	// getFullHistTx()
+	currentHeight, err := getTipHeight(ctx, cc)
+	if err != nil {
+		return AddressPage{}, err
+	}
 
-	fullHistTxs := make([]FullHistTransaction, 0)
+	// Unconfirmed transactions have a height of 0 or -1
+	var confirmed, unconfirmed []HistoryTransaction
 	for _, t := range histTxs {
-		tx := getFullHistTx(t, addr)
-		fullHistTxs = append(fullHistTxs, tx)
+		if t.Height > 0 {
+			confirmed = append(confirmed, t)
+		} else {
+			unconfirmed = append(unconfirmed, t)
+		}
 	}
-
-	// Sort the array by blockheight in ascending order
-	sort.Slice(fullHistTxs, func(i, j int) bool {
-		return fullHistTxs[i].Height < fullHistTxs[j].Height
+	sort.Slice(confirmed, func(i, j int) bool {
+		return histBefore(confirmed[i], confirmed[j])
 	})
 
-	// Calculate balance history
-	balance := 0.0
-	balHist := make([]AddrBalHistory, 0)
-
-	balHist = append(balHist, AddrBalHistory{fullHistTxs[0].Height - 1, 0.0})
+	resolved := make(map[string]FullHistTransaction)
+	resolve := func(txs []HistoryTransaction) error {
+		var missing []HistoryTransaction
+		for _, t := range txs {
+			if _, ok := resolved[t.TxHash]; !ok {
+				missing = append(missing, t)
+			}
+		}
+		fullTxs, err := resolveHistTxs(ctx, missing, addr, currentHeight, cc)
+		if err != nil {
+			return err
+		}
+		for txid, fullTx := range fullTxs {
+			resolved[txid] = fullTx
+			changes[txid] = fullTx.BalanceChange
+		}
+		return nil
+	}
+	withoutChange := func(txs []HistoryTransaction) []HistoryTransaction {
+		var missing []HistoryTransaction
+		for _, t := range txs {
+			if _, ok := changes[t.TxHash]; !ok {
+				missing = append(missing, t)
+			}
+		}
+		return missing
+	}
 
-	for i, tx := range fullHistTxs {
-		balChange := getBalanceChange(tx, addr)
-		balance += balChange
-		fullHistTxs[i].BalanceChange = balChange
-		balHist = append(balHist, AddrBalHistory{tx.Height, balance})
+	if err := resolve(unconfirmed); err != nil {
+		return AddressPage{}, err
 	}
-	currentHeight, _ := getBlockHeight(18443)
-	if balHist[len(balHist)-1].Block != currentHeight {
-		balHist = append(balHist, AddrBalHistory{currentHeight, balHist[len(balHist)-1].Balance})
+	page.Pending, err = pendingTxs(ctx, unconfirmed, resolved, cc)
+	if err != nil {
+		return AddressPage{}, err
+	}
+	page.Balance.UnconfirmedTxs = make([]UnconfirmedChange, 0, len(page.Pending))
+	for _, tx := range page.Pending {
+		page.Balance.UnconfirmedTxs = append(page.Balance.UnconfirmedTxs, UnconfirmedChange{
+			TxID:   tx.TxID,
+			Change: tx.BalanceChange,
+		})
 	}
 
-	// Need ascending order for return
-	length := len(fullHistTxs)
-	for i := 0; i < length/2; i++ {
-		fullHistTxs[i], fullHistTxs[length-i-1] = fullHistTxs[length-i-1], fullHistTxs[i]
+	// The balance history needs every balance change. Without the index that
+	// means fetching the whole history, which is only done when it fits in a
+	// page anyway. Otherwise the page says it was left out.
+	if q.Cursor == "" {
+		if missing := withoutChange(confirmed); len(missing) <= q.Limit {
+			if err := resolve(missing); err != nil {
+				return AddressPage{}, err
+			}
+			page.BalanceHistory = balanceHistory(confirmed, changes, currentHeight)
+		} else {
+			page.BalanceHistoryTruncated = true
+		}
 	}
 
-	return fullHistTxs, balHist, addrBal
+	var matches []HistoryTransaction
+	for _, t := range confirmed {
+		if q.matchesHeight(t) {
+			matches = append(matches, t)
+		}
+	}
+	if q.needsChanges() {
+		if err := resolve(withoutChange(matches)); err != nil {
+			return AddressPage{}, err
+		}
+		filtered := matches[:0]
+		for _, t := range matches {
+			if change, ok := changes[t.TxHash]; ok && q.matchesChange(change) {
+				filtered = append(filtered, t)
+			}
+		}
+		matches = filtered
+	}
+	page.Total = len(matches)
+
+	start := 0
+	if q.Cursor != "" {
+		cursor, _ := parseHistCursor(q.Cursor)
+		start = sort.Search(len(matches), func(i int) bool {
+			return histBefore(cursor, matches[i])
+		})
+	}
+	end := start + q.Limit
+	if end > len(matches) {
+		end = len(matches)
+	}
+	pageTxs := matches[start:end]
+	if end < len(matches) {
+		page.NextCursor = histCursor(pageTxs[len(pageTxs)-1])
+	}
 
+	if err := resolve(pageTxs); err != nil {
+		return AddressPage{}, err
+	}
+	for _, t := range pageTxs {
+		page.TxHistory = append(page.TxHistory, resolved[t.TxHash])
+	}
+	return page, nil
 }
 
 func getFullHistTxs(histTxs []HistoryTransaction, addr string) {
@@ -691,9 +957,9 @@ func getFullHistTxs(histTxs []HistoryTransaction, addr string) {
 
 }
 
-func getBalanceChange(fullTx FullHistTransaction, addr string) float64 {
-	inputVal := 0.0
-	outputVal := 0.0
+func getBalanceChange(fullTx FullHistTransaction, addr string) Amount {
+	var inputVal Amount
+	var outputVal Amount
 
 	for _, vin := range fullTx.Vin {
 		if vin.Address == addr {
@@ -710,132 +976,169 @@ func getBalanceChange(fullTx FullHistTransaction, addr string) float64 {
 	return outputVal - inputVal
 }
 
-func getFullHistTx(histTx HistoryTransaction, addr string) FullHistTransaction {
-
-	tx, _ := getTx(histTx.TxHash, 50001)
-
-	currentHeight, _ := getBlockHeight(18443)
-
+func getFullHistTx(histTx HistoryTransaction, tx ElectrumTransaction, addr string, currentHeight int, prevTxs map[string]ElectrumTransaction, cc *chainConfig) (FullHistTransaction, error) {
 	var fullTx FullHistTransaction
 	fullTx.TxID = tx.TxID
-	fullTx.Confirmations = currentHeight - histTx.Height
+	// Unconfirmed transactions have a height of 0 or -1
+	if histTx.Height > 0 {
+		fullTx.Confirmations = currentHeight - histTx.Height + 1
+	}
 	fullTx.Height = histTx.Height
 	fullTx.Size = tx.Size
 	fullTx.VSize = tx.Vsize
 	fullTx.Hex = tx.Hex
-	// Loop over transaction OUTPUTS
-	for _, vout := range tx.Vout {
-		if vout.Value > 0 {
-			// Add to fullVout struct and append to vout array in fullTx
-			fullVin := FullVout{Amount: vout.Value, Index: vout.N, Address: vout.ScriptPubKey.Address}
-			fullTx.Vout = append(fullTx.Vout, fullVin)
-		}
-	}
-
-	// Loop over transaction INPUTS
-	for _, vin := range tx.Vin {
-		// Block Rewards won't have a TxId
-		if vin.TxID != "" {
-			// Get transaction associated with this inputs tx id
-			vinTx, err := getTx(vin.TxID, 50001)
-			if err != nil {
-				// fmt.Println("286: ", err)
-				// return 0.0, err
-			}
-
-			// Assign address associated with specific output of this tx
-			address := vinTx.Vout[vin.Vout].ScriptPubKey.Address
-
-			fullVin := FullVin{vin.TxID, vinTx.Vout[vin.Vout].Value, vin.Vout, address}
-			fullTx.Vin = append(fullTx.Vin, fullVin)
-		}
+	fullTx.Vout = fullVouts(tx, cc)
+	vins, err := fullVins(tx, prevTxs)
+	if err != nil {
+		return FullHistTransaction{}, err
 	}
+	fullTx.Vin = vins
 
-	return fullTx
+	return fullTx, nil
 }
 
-func getAddressHist(scriptHash string) []HistoryTransaction {
+// getAddressHist returns the history of a script, and the balance changes of
+// the transactions in it that are known without fetching them. Once the index
+// has caught up, only the unconfirmed part is asked of the Electrum server and
+// the changes of the confirmed part come from the index.
+func getAddressHist(ctx context.Context, scriptHash string, cc *chainConfig) ([]HistoryTransaction, map[string]Amount, error) {
 	params := []any{scriptHash}
-	reqJSON := createElectrumRequest("blockchain.scripthash.get_history", params)
-	elecRes := sendElectrumRequest(reqJSON)
-	var response Response
-	fmt.Println("=====================")
-	fmt.Println(elecRes)
-
-	// Unmarshal JSON data into the struct
-	if err := json.Unmarshal([]byte(elecRes), &response); err != nil {
+	changes := make(map[string]Amount)
+
+	if cc.index.synced() {
+		indexed, err := cc.index.history(scriptHash)
+		if err == nil {
+			history := make([]HistoryTransaction, 0, len(indexed))
+			for _, t := range indexed {
+				history = append(history, t.HistoryTransaction)
+				changes[t.TxHash] = t.Delta
+			}
+			var mempool []HistoryTransaction
+			if err := cc.electrum.call(ctx, "blockchain.scripthash.get_mempool", params, &mempool); err != nil {
+				return nil, nil, err
+			}
+			return append(history, mempool...), changes, nil
+		}
 		fmt.Println("Error:", err)
-		return []HistoryTransaction{}
 	}
-	return response.Result
+
+	var history []HistoryTransaction
+	if err := cc.electrum.call(ctx, "blockchain.scripthash.get_history", params, &history); err != nil {
+		return nil, nil, err
+	}
+	return history, changes, nil
 }
 
-func getAddressBal(scriptHash string) AddrBal {
+func getAddressBal(ctx context.Context, scriptHash string, cc *chainConfig) (AddrBal, error) {
 	params := []any{scriptHash}
-	reqJSON := createElectrumRequest("blockchain.scripthash.get_balance", params)
-	elecRes := sendElectrumRequest(reqJSON)
-	fmt.Println(elecRes)
-	var response BalanceResponse
 
-	// Unmarshal JSON data into the struct
-	if err := json.Unmarshal([]byte(elecRes), &response); err != nil {
-		fmt.Println("Error:", err)
-		return AddrBal{}
+	var balance AddrBal
+	if err := cc.electrum.call(ctx, "blockchain.scripthash.get_balance", params, &balance); err != nil {
+		return AddrBal{}, err
 	}
-	return response.Result
+	return balance, nil
 }
 
+func blockReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
 
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
 
-func nmcBlockReq(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		// Define a struct to unmarshal the JSON data
+		var req struct {
+			BlockHash   string `json:"blockhash"`
+			BlockHeight int    `json:"blockheight"`
+			//struct fields here
+		}
+
+		// Unmarshal the JSON data
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		//================================================================================//
+		//============================== Code Goes Here ==================================//
+		//================================================================================//
+
+		if req.BlockHash == "" && req.BlockHeight == 0 {
+			writeError(w, http.StatusBadRequest, "Invalid Request Body")
+			return
+		}
+
+		if req.BlockHash == "" {
+			req.BlockHash, err = getBlockHash(r.Context(), req.BlockHeight, cc)
+			if err != nil {
+				writeBlockHashError(w, err)
+				return
+			}
+		}
+
+		serveBlock(w, r, req.BlockHash, cc)
+		//================================================================================//
+		//================================================================================//
+		//================================================================================//
 	}
+}
 
-	// Read the request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
+// blockGetReq handles GET /block/{id}, where id is a block hash or height.
+func blockGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		hash := id
+		if height, err := strconv.Atoi(id); err == nil && len(id) < 64 {
+			hash, err = getBlockHash(r.Context(), height, cc)
+			if err != nil {
+				writeBlockHashError(w, err)
+				return
+			}
+		}
+		serveBlock(w, r, hash, cc)
 	}
+}
 
-	// Define a struct to unmarshal the JSON data
-	var req struct {
-		BlockHash   string `json:"blockhash"`
-		BlockHeight int    `json:"blockheight"`
-		//struct fields here
+// writeBlockHashError writes the response for a failed lookup of a block by
+// height. Core rejects heights above the tip as invalid parameters.
+func writeBlockHashError(w http.ResponseWriter, err error) {
+	if isRPCError(err, rpcErrInvalidParameter) {
+		writeError(w, http.StatusNotFound, "Block not found")
+		return
 	}
+	writeBackendError(w, err, "block")
+}
 
-	// Unmarshal the JSON data
-	err = json.Unmarshal(body, &req)
+func serveBlock(w http.ResponseWriter, r *http.Request, hash string, cc *chainConfig) {
+	unit, err := requestUnit(r)
 	if err != nil {
-		http.Error(w, "Error unmarshaling JSON data", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	//================================================================================//
-	//============================== Code Goes Here ==================================//
-	//================================================================================//
-
-	if req.BlockHash == "" && req.BlockHeight == 0 {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+	if !isHash(hash) {
+		writeError(w, http.StatusBadRequest, "Invalid block hash")
 		return
 	}
 
-	if req.BlockHash == "" {
-		req.BlockHash, _ = getBlockHash(req.BlockHeight, 18443)
+	block, err := getBlockData(r.Context(), hash, cc)
+	if err != nil {
+		writeBackendError(w, err, "block")
+		return
 	}
 
-	block := getBlockData(req.BlockHash, "nmc")
-	//================================================================================//
-	//================================================================================//
-	//================================================================================//
-
 	// // Marshal the struct into JSON
-	resJSON, err := json.Marshal(block)
+	resJSON, err := marshalWithUnit(block, unit)
 	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
 		return
 	}
 
@@ -845,15 +1148,123 @@ func nmcBlockReq(w http.ResponseWriter, r *http.Request) {
 	w.Write(resJSON)
 }
 
-func getBlockData(blockHash string, chain string) FullBlock {
-	port := 0
-	if chain == "nmc" {
-		port = 18443
-	} else if chain == "btc" {
-		port = 0
+func getBlockData(ctx context.Context, blockHash string, cc *chainConfig) (FullBlock, error) {
+	if indexed, ok := cc.index.block(blockHash); ok {
+		fullBlock := newFullBlock(indexed.Block)
+		for _, txid := range indexed.TxIDs {
+			if tx, ok := cc.index.tx(txid); ok {
+				// Only confirmed spends; checking the mempool for every
+				// output of a block would be too slow
+				fullTx := tx.fullTx(cc)
+				fullTx.Vout = withOutspends(fullTx.Vout, indexedOutspends(tx, cc))
+				fullBlock.Tx = append(fullBlock.Tx, fullTx)
+			}
+		}
+		return fullBlock, nil
+	}
+
+	if cached, ok := cc.cache.get(cacheFullBlock, blockHash); ok {
+		if confs, ok := checkCachedBlocks(ctx, []string{blockHash}, cc)[blockHash]; ok {
+			fullBlock := cached.(FullBlock)
+			fullBlock.Confirmations = confs
+			return fullBlock, nil
+		}
+	}
+
+	block, err := getBlock(ctx, blockHash, cc)
+	if err != nil {
+		return FullBlock{}, err
+	}
+	fullBlock := newFullBlock(block)
+
+	// Fetch the block's transactions and then everything they spend, one
+	// batch each. The height is already known, so there is no need to look up
+	// the block again for every transaction
+	txids := make([]string, 0, len(block.Tx))
+	for _, tx := range block.Tx {
+		txids = append(txids, tx.TxID)
+	}
+	txs, err := getTxs(ctx, txids, cc)
+	if err != nil {
+		return FullBlock{}, err
+	}
+	electrumTxs := make([]ElectrumTransaction, 0, len(txs))
+	for _, txid := range txids {
+		electrumTxs = append(electrumTxs, txs[txid])
+	}
+	prevTxs, err := getPrevTxs(ctx, electrumTxs, cc)
+	if err != nil {
+		return FullBlock{}, err
+	}
+
+	for _, electrumTx := range electrumTxs {
+		fullTx, err := buildFullTx(electrumTx, int(block.Height), prevTxs, cc)
+		if err != nil {
+			return FullBlock{}, err
+		}
+		fullBlock.Tx = append(fullBlock.Tx, fullTx)
+	}
+
+	if block.Confirmations > 0 {
+		cc.cache.add(cacheFullBlock, blockHash, fullBlock, len(fullBlock.Tx), blockHash)
+	}
+
+	return fullBlock, nil
+}
+
+// getFullTx returns a transaction with its outputs' spends. The spends change
+// over time, so they are looked up every time rather than cached.
+func getFullTx(ctx context.Context, txid string, cc *chainConfig) (FullTransaction, error) {
+	fullTx, tx, err := loadFullTx(ctx, txid, cc)
+	if err != nil {
+		return FullTransaction{}, err
+	}
+	outspends, err := getOutspends(ctx, tx, cc)
+	if err != nil {
+		return FullTransaction{}, err
+	}
+	fullTx.Vout = withOutspends(fullTx.Vout, outspends)
+	return fullTx, nil
+}
+
+// loadFullTx returns a transaction and the Electrum form it was built from.
+func loadFullTx(ctx context.Context, txid string, cc *chainConfig) (FullTransaction, ElectrumTransaction, error) {
+	if indexed, ok := cc.index.tx(txid); ok {
+		return indexed.fullTx(cc), indexed.Tx, nil
+	}
+
+	tx, err := getTx(ctx, txid, cc)
+	if err != nil {
+		return FullTransaction{}, ElectrumTransaction{}, err
+	}
+	if cached, ok := cc.cache.get(cacheFullTx, txid); ok {
+		return cached.(FullTransaction), tx, nil
+	}
+
+	// Only the height is needed, so the header is enough. Unconfirmed
+	// transactions have no block.
+	var header BlockHeaderData
+	if tx.BlockHash != "" {
+		header, err = cc.rpc.getBlockHeader(ctx, tx.BlockHash)
+		if err != nil {
+			return FullTransaction{}, ElectrumTransaction{}, err
+		}
+	}
+
+	prevTxs, err := getPrevTxs(ctx, []ElectrumTransaction{tx}, cc)
+	if err != nil {
+		return FullTransaction{}, ElectrumTransaction{}, err
 	}
+	fullTx, err := buildFullTx(tx, header.Height, prevTxs, cc)
+	if err != nil {
+		return FullTransaction{}, ElectrumTransaction{}, err
+	}
+	cc.cache.add(cacheFullTx, txid, fullTx, 1, tx.BlockHash)
+	return fullTx, tx, nil
+}
 
-	block, _ := getBlock(blockHash, port)
+// newFullBlock returns a FullBlock with the header fields of block filled in.
+func newFullBlock(block BlockData) FullBlock {
 	var fullBlock FullBlock
 	fullBlock.Weight = block.Weight
 	fullBlock.Bits = block.Bits
@@ -872,55 +1283,72 @@ func getBlockData(blockHash string, chain string) FullBlock {
 	fullBlock.PreviousBlockHash = block.PreviousBlockHash
 	fullBlock.Height = block.Height
 	fullBlock.StrippedSize = block.StrippedSize
-
-	for _, tx := range block.Tx {
-		fullTx := getFullTx(tx.TxID)
-		fullBlock.Tx = append(fullBlock.Tx, fullTx)
-	}
-
 	return fullBlock
 }
 
-func getFullTx(txid string) FullTransaction {
-
-	tx, _ := getTx(txid, 50001)
-
+// buildFullTx builds a transaction at a known height, with its inputs
+// resolved from prevTxs.
+func buildFullTx(tx ElectrumTransaction, height int, prevTxs map[string]ElectrumTransaction, cc *chainConfig) (FullTransaction, error) {
 	var fullTx FullTransaction
 	fullTx.TxID = tx.TxID
-	// fullTx.Height = histTx.Height
-	block, _ := getBlock(tx.BlockHash, 18443)
-
-	fullTx.Height = int(block.Height)
+	fullTx.Height = height
 	fullTx.Size = tx.Size
 	fullTx.VSize = tx.Vsize
 	fullTx.Hex = tx.Hex
+	fullTx.Vout = fullVouts(tx, cc)
+	vins, err := fullVins(tx, prevTxs)
+	if err != nil {
+		return FullTransaction{}, err
+	}
+	fullTx.Vin = vins
+
+	return fullTx, nil
+}
+
+// fullVouts lists the outputs of tx with their name operations decoded.
+func fullVouts(tx ElectrumTransaction, cc *chainConfig) []FullVout {
+	var vouts []FullVout
 	// Loop over transaction OUTPUTS
 	for _, vout := range tx.Vout {
 		if vout.Value > 0 {
-			// Add to fullVout struct and append to vout array in fullTx
-			fullVin := FullVout{Amount: vout.Value, Index: vout.N, Address: vout.ScriptPubKey.Address}
-			fullTx.Vout = append(fullTx.Vout, fullVin)
+			fullVout := FullVout{
+				Amount:  toSats(vout.Value),
+				Index:   vout.N,
+				Address: vout.ScriptPubKey.Address,
+				Script:  vout.ScriptPubKey.Hex,
+				NameOp:  decodeNameOp(vout.ScriptPubKey.Hex, cc),
+			}
+			if fullVout.Address == "" && fullVout.NameOp != nil {
+				fullVout.Address = fullVout.NameOp.Address
+			}
+			vouts = append(vouts, fullVout)
 		}
 	}
+	return vouts
+}
 
+// fullVins resolves the amount and address of each input from the output it
+// spends in prevTxs, which has to hold every transaction tx spends from.
+func fullVins(tx ElectrumTransaction, prevTxs map[string]ElectrumTransaction) ([]FullVin, error) {
+	var vins []FullVin
 	// Loop over transaction INPUTS
 	for _, vin := range tx.Vin {
 		// Block Rewards won't have a TxId
-		if vin.TxID != "" {
-			// Get transaction associated with this inputs tx id
-			vinTx, err := getTx(vin.TxID, 50001)
-			if err != nil {
-				// fmt.Println("286: ", err)
-				// return 0.0, err
-			}
-
-			// Assign address associated with specific output of this tx
-			address := vinTx.Vout[vin.Vout].ScriptPubKey.Address
+		if vin.TxID == "" {
+			continue
+		}
 
-			fullVin := FullVin{vin.TxID, vinTx.Vout[vin.Vout].Value, vin.Vout, address}
-			fullTx.Vin = append(fullTx.Vin, fullVin)
+		prevTx, ok := prevTxs[vin.TxID]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return nil, fmt.Errorf("output %s:%d spent by %s is missing", vin.TxID, vin.Vout, tx.TxID)
 		}
+		prevOut := prevTx.Vout[vin.Vout]
+		vins = append(vins, FullVin{
+			TxID:    vin.TxID,
+			Index:   vin.Vout,
+			Amount:  toSats(prevOut.Value),
+			Address: prevOut.ScriptPubKey.Address,
+		})
 	}
-
-	return fullTx
-}
\ No newline at end of file
+	return vins, nil
+}
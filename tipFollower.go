@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// tipWindow is how many of the most recent main chain blocks the follower
+	// remembers, which is also the deepest reorg it can describe.
+	tipWindow = 100
+
+	// reorgHistory is how many reorg events are kept for the reorgs endpoint.
+	reorgHistory = 20
+)
+
+// BlockRef identifies a block.
+type BlockRef struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// ReorgEvent describes the node switching to a different branch. Fork is the
+// last block both branches have in common and Orphaned the blocks of the old
+// branch after it, Depth of them.
+type ReorgEvent struct {
+	Chain    string   `json:"chain"`
+	Time     int64    `json:"time"`
+	Depth    int      `json:"depth"`
+	Fork     BlockRef `json:"fork"`
+	OldTip   BlockRef `json:"oldtip"`
+	NewTip   BlockRef `json:"newtip"`
+	Orphaned []string `json:"orphaned"`
+}
+
+// tipFollower keeps track of the node's best chain. It checks for a new tip
+// every TipPoll, and whenever the Electrum server announces a new header. When
+// a new tip does not build on the tip it knew, it walks back to the fork point
+// and emits a ReorgEvent.
+type tipFollower struct {
+	cc   *chainConfig
+	wake chan struct{}
+
+	mu      sync.Mutex
+	chain   []BlockRef // the most recent blocks, by ascending height
+	reorgs  []ReorgEvent
+	onReorg []func(ReorgEvent)
+	onTip   []func(BlockRef)
+}
+
+func newTipFollower(cc *chainConfig) *tipFollower {
+	return &tipFollower{cc: cc, wake: make(chan struct{}, 1)}
+}
+
+// handleReorg registers fn to be called with every reorg.
+func (f *tipFollower) handleReorg(fn func(ReorgEvent)) {
+	f.mu.Lock()
+	f.onReorg = append(f.onReorg, fn)
+	f.mu.Unlock()
+}
+
+// handleTip registers fn to be called with every new tip, after any reorg
+// leading up to it has been handled.
+func (f *tipFollower) handleTip(fn func(BlockRef)) {
+	f.mu.Lock()
+	f.onTip = append(f.onTip, fn)
+	f.mu.Unlock()
+}
+
+// tip returns the best block as last seen, if the follower has seen one yet.
+func (f *tipFollower) tip() (BlockRef, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.chain) == 0 {
+		return BlockRef{}, false
+	}
+	return f.chain[len(f.chain)-1], true
+}
+
+func (f *tipFollower) recentReorgs() []ReorgEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ReorgEvent{}, f.reorgs...)
+}
+
+func (f *tipFollower) run() {
+	f.cc.electrum.subscribe("blockchain.headers.subscribe", nil, func(json.RawMessage) {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	})
+
+	ticker := time.NewTicker(f.cc.TipPoll)
+	defer ticker.Stop()
+	for {
+		if err := f.check(context.Background()); err != nil {
+			fmt.Println("Error following", f.cc.name, "tip:", err)
+		}
+		select {
+		case <-f.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// check looks for a new tip and handles it.
+func (f *tipFollower) check(ctx context.Context) error {
+	best, err := f.cc.rpc.getBestBlockHash(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	chain := append([]BlockRef(nil), f.chain...)
+	f.mu.Unlock()
+	if len(chain) > 0 && chain[len(chain)-1].Hash == best {
+		return nil
+	}
+
+	header, err := f.cc.rpc.getBlockHeader(ctx, best)
+	if err != nil {
+		return err
+	}
+	newTip := BlockRef{Height: header.Height, Hash: header.Hash}
+
+	// Find the last block we know that is still in the node's chain. Usually
+	// that is our tip, which the new block builds on.
+	fork := len(chain) - 1
+	if len(chain) > 0 && header.PreviousBlockHash != chain[fork].Hash {
+		for ; fork >= 0; fork-- {
+			if chain[fork].Height > newTip.Height {
+				continue
+			}
+			hash, err := f.cc.rpc.getBlockHash(ctx, chain[fork].Height)
+			if err != nil {
+				return err
+			}
+			if hash == chain[fork].Hash {
+				break
+			}
+		}
+	}
+
+	var event *ReorgEvent
+	if fork < len(chain)-1 {
+		event = &ReorgEvent{
+			Chain:  f.cc.name,
+			Time:   time.Now().Unix(),
+			Depth:  len(chain) - 1 - fork,
+			OldTip: chain[len(chain)-1],
+			NewTip: newTip,
+		}
+		if fork >= 0 {
+			event.Fork = chain[fork]
+		} else {
+			// Deeper than we can see; all we know is where our window starts.
+			fmt.Println("Reorg on", f.cc.name, "is deeper than", tipWindow, "blocks")
+			event.Fork = BlockRef{Height: chain[0].Height - 1}
+		}
+		for _, block := range chain[fork+1:] {
+			event.Orphaned = append(event.Orphaned, block.Hash)
+		}
+	}
+
+	// Fetch the new branch, or the last tipWindow blocks on the first run
+	start := newTip.Height - tipWindow + 1
+	if fork >= 0 && chain[fork].Height+1 > start {
+		start = chain[fork].Height + 1
+	}
+	if start < 0 {
+		start = 0
+	}
+	var heights []int
+	for h := start; h <= newTip.Height; h++ {
+		heights = append(heights, h)
+	}
+	hashes, err := f.cc.rpc.getBlockHashes(ctx, heights)
+	if err != nil {
+		return err
+	}
+
+	if fork >= 0 && start > chain[fork].Height+1 {
+		// Too far behind to keep anything
+		chain = nil
+	} else {
+		chain = chain[:fork+1]
+	}
+	for i, hash := range hashes {
+		chain = append(chain, BlockRef{Height: heights[i], Hash: hash})
+	}
+	if len(chain) > tipWindow {
+		chain = chain[len(chain)-tipWindow:]
+	}
+
+	f.mu.Lock()
+	f.chain = chain
+	onReorg := append([]func(ReorgEvent){}, f.onReorg...)
+	onTip := append([]func(BlockRef){}, f.onTip...)
+	if event != nil {
+		f.reorgs = append(f.reorgs, *event)
+		if len(f.reorgs) > reorgHistory {
+			f.reorgs = f.reorgs[len(f.reorgs)-reorgHistory:]
+		}
+	}
+	f.mu.Unlock()
+
+	if event != nil {
+		fmt.Printf("Reorg on %s: depth %d, fork at %d, old tip %d %s, new tip %d %s\n",
+			f.cc.name, event.Depth, event.Fork.Height,
+			event.OldTip.Height, event.OldTip.Hash, event.NewTip.Height, event.NewTip.Hash)
+		for _, fn := range onReorg {
+			fn(*event)
+		}
+	}
+	for _, fn := range onTip {
+		fn(newTip)
+	}
+	return nil
+}
+
+func reorgsReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resJSON, err := json.Marshal(cc.follower.recentReorgs())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Error marshaling data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resJSON)
+	}
+}
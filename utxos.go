@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// utxoPageSize is how many outputs the utxos endpoint returns when the
+	// request does not say, and utxoMaxPageSize the most it returns at once.
+	utxoPageSize    = 100
+	utxoMaxPageSize = 1000
+)
+
+// ElectrumUnspent is an entry of blockchain.scripthash.listunspent.
+type ElectrumUnspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  int    `json:"tx_pos"`
+	Height int    `json:"height"`
+	Value  Amount `json:"value"`
+}
+
+// UTXO is an unspent output of an address. Height is 0 while the output is
+// unconfirmed. ScriptPubKey is the output's whole script, and ScriptType the
+// type of the part paying the address. NameOp is set for Namecoin name
+// outputs, which hold a name and must not be spent as ordinary coins.
+type UTXO struct {
+	TxID          string  `json:"txid"`
+	Vout          int     `json:"vout"`
+	Value         Amount  `json:"value"`
+	Height        int     `json:"height"`
+	Confirmations int     `json:"confirmations"`
+	ScriptPubKey  string  `json:"scriptpubkey"`
+	ScriptType    string  `json:"scripttype"`
+	NameOp        *NameOp `json:"nameop,omitempty"`
+}
+
+// UTXOPage is one page of an address's unspent outputs. Total is the number
+// of outputs that pass the confirmation filter, over all pages.
+type UTXOPage struct {
+	Address string `json:"address"`
+	Total   int    `json:"total"`
+	Offset  int    `json:"offset"`
+	Limit   int    `json:"limit"`
+	UTXOs   []UTXO `json:"utxos"`
+}
+
+// getUTXOs returns the unspent outputs of addr selected by q, oldest first
+// with the unconfirmed ones last.
+func getUTXOs(ctx context.Context, addr string, q utxoQuery, cc *chainConfig) (UTXOPage, error) {
+	decoded, err := btcutil.DecodeAddress(addr, cc.params)
+	if err != nil {
+		return UTXOPage{}, err
+	}
+	script, err := txscript.PayToAddrScript(decoded)
+	if err != nil {
+		return UTXOPage{}, err
+	}
+
+	var unspent []ElectrumUnspent
+	params := []any{hex.EncodeToString(scriptHash(script))}
+	if err := cc.electrum.call(ctx, "blockchain.scripthash.listunspent", params, &unspent); err != nil {
+		return UTXOPage{}, err
+	}
+
+	currentHeight, err := getTipHeight(ctx, cc)
+	if err != nil {
+		return UTXOPage{}, err
+	}
+
+	utxos := make([]UTXO, 0, len(unspent))
+	for _, u := range unspent {
+		utxo := UTXO{TxID: u.TxHash, Vout: u.TxPos, Value: u.Value}
+		// Unconfirmed outputs have a height of 0 or -1
+		if u.Height > 0 {
+			utxo.Height = u.Height
+			utxo.Confirmations = currentHeight - u.Height + 1
+		}
+		if utxo.Confirmations >= q.MinConf {
+			utxos = append(utxos, utxo)
+		}
+	}
+
+	// Name outputs pay to the address too, and can only be told apart by
+	// their scripts, so leaving them out needs the scripts of all outputs
+	// before paginating. Otherwise only the page's scripts are needed.
+	filterNames := cc.hasNames() && !q.IncludeNames
+	if filterNames {
+		if err := fillUTXOScripts(ctx, utxos, cc); err != nil {
+			return UTXOPage{}, err
+		}
+		coins := utxos[:0]
+		for _, utxo := range utxos {
+			if utxo.NameOp == nil {
+				coins = append(coins, utxo)
+			}
+		}
+		utxos = coins
+	}
+
+	sort.Slice(utxos, func(i, j int) bool {
+		a, b := utxos[i], utxos[j]
+		if a.Height != b.Height {
+			if a.Height == 0 || b.Height == 0 {
+				return b.Height == 0
+			}
+			return a.Height < b.Height
+		}
+		if a.TxID != b.TxID {
+			return a.TxID < b.TxID
+		}
+		return a.Vout < b.Vout
+	})
+
+	page := UTXOPage{Address: addr, Total: len(utxos), Offset: q.Offset, Limit: q.Limit}
+	offset := q.Offset
+	if offset > len(utxos) {
+		offset = len(utxos)
+	}
+	end := offset + q.Limit
+	if end > len(utxos) {
+		end = len(utxos)
+	}
+	page.UTXOs = utxos[offset:end]
+	if !filterNames {
+		if err := fillUTXOScripts(ctx, page.UTXOs, cc); err != nil {
+			return UTXOPage{}, err
+		}
+	}
+	return page, nil
+}
+
+// fillUTXOScripts sets the scripts of utxos. They are read from the index
+// where it has the outputs, and from the transactions that created them
+// otherwise, which is the case for unconfirmed outputs and while the index
+// is catching up.
+func fillUTXOScripts(ctx context.Context, utxos []UTXO, cc *chainConfig) error {
+	indexed := make(map[int]string, len(utxos))
+	var missing []string
+	for i, utxo := range utxos {
+		var out indexedOutput
+		if cc.index.lookup(outKey(utxo.TxID, utxo.Vout), &out) {
+			indexed[i] = out.Script
+		} else {
+			missing = append(missing, utxo.TxID)
+		}
+	}
+	txs, err := getTxs(ctx, missing, cc)
+	if err != nil {
+		return err
+	}
+
+	for i := range utxos {
+		utxo := &utxos[i]
+		scriptHex, ok := indexed[i]
+		if !ok {
+			vouts := txs[utxo.TxID].Vout
+			if utxo.Vout < 0 || utxo.Vout >= len(vouts) {
+				return fmt.Errorf("unspent output %s:%d is missing", utxo.TxID, utxo.Vout)
+			}
+			scriptHex = vouts[utxo.Vout].ScriptPubKey.Hex
+		}
+		script, err := hex.DecodeString(scriptHex)
+		if err != nil {
+			return fmt.Errorf("invalid script of %s:%d", utxo.TxID, utxo.Vout)
+		}
+		utxo.ScriptPubKey = scriptHex
+		utxo.ScriptType = txscript.GetScriptClass(addressScript(script, cc)).String()
+		utxo.NameOp = decodeNameOp(scriptHex, cc)
+	}
+	return nil
+}
+
+// utxoQuery selects a page of an address's unspent outputs. Name outputs are
+// left out unless IncludeNames is set.
+type utxoQuery struct {
+	MinConf      int  `json:"minconf"`
+	Offset       int  `json:"offset"`
+	Limit        int  `json:"limit"`
+	IncludeNames bool `json:"includenames"`
+}
+
+func utxosReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Read the request body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error reading request body")
+			return
+		}
+
+		var req struct {
+			Address string `json:"address"`
+			utxoQuery
+		}
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Error unmarshaling JSON data")
+			return
+		}
+
+		serveUTXOs(w, r, req.Address, req.utxoQuery, cc)
+	}
+}
+
+// utxosGetReq handles GET /address/{addr}/utxos, with the options as query
+// parameters.
+func utxosGetReq(cc *chainConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var q utxoQuery
+		values := r.URL.Query()
+		for name, v := range map[string]*int{"minconf": &q.MinConf, "offset": &q.Offset, "limit": &q.Limit} {
+			if s := values.Get(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid query: invalid %s %q", name, s))
+					return
+				}
+				*v = n
+			}
+		}
+		if s := values.Get("includenames"); s != "" {
+			include, err := strconv.ParseBool(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid query: invalid includenames %q", s))
+				return
+			}
+			q.IncludeNames = include
+		}
+		serveUTXOs(w, r, mux.Vars(r)["addr"], q, cc)
+	}
+}
+
+func serveUTXOs(w http.ResponseWriter, r *http.Request, addr string, q utxoQuery, cc *chainConfig) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if addr == "" || q.MinConf < 0 || q.Offset < 0 || q.Limit < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid Request Body")
+		return
+	}
+	if _, err := btcutil.DecodeAddress(addr, cc.params); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid address")
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = utxoPageSize
+	}
+	if q.Limit > utxoMaxPageSize {
+		q.Limit = utxoMaxPageSize
+	}
+
+	page, err := getUTXOs(r.Context(), addr, q, cc)
+	if err != nil {
+		writeBackendError(w, err, "unspent outputs")
+		return
+	}
+
+	resJSON, err := marshalWithUnit(page, unit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error marshaling data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resJSON)
+}
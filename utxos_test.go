@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestFillUTXOScriptsFromIndex(t *testing.T) {
+	// The chain has no Electrum client, so the scripts can only come from
+	// the index
+	cc := newTestNameChain(t, map[string]string{"d/example": "{}"})
+	utxos := []UTXO{{TxID: fmt.Sprintf("%064x", 100), Vout: 0}}
+	if err := fillUTXOScripts(context.Background(), utxos, cc); err != nil {
+		t.Fatal(err)
+	}
+	utxo := utxos[0]
+	if utxo.ScriptPubKey == "" || utxo.ScriptType != "pubkeyhash" {
+		t.Errorf("got script %q of type %q", utxo.ScriptPubKey, utxo.ScriptType)
+	}
+	if utxo.NameOp == nil || utxo.NameOp.Name != "d/example" {
+		t.Errorf("got name op %+v, want d/example", utxo.NameOp)
+	}
+}